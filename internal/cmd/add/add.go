@@ -2,25 +2,27 @@ package add
 
 import (
 	"fmt"
-	"os"
+	"io"
 
 	"github.com/spf13/cobra"
-	"go.yaml.in/yaml/v4"
 
 	"github.com/kyle-burnett/simple-ipam/internal/models"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
 )
 
-var subnet, description, inputFile string
+var subnet, description, inputFile, output string
 var tags []string
+var opts fileutil.UpdateOptions
 
 var AddCmd = &cobra.Command{
 	Use:          "add",
 	Short:        "Add a subnet to an IPAM file",
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return Add(inputFile, subnet, description, tags)
+		return Add(cmd.OutOrStdout(), inputFile, subnet, description, tags, output, opts)
 	},
 }
 
@@ -31,30 +33,42 @@ func init() {
 	_ = AddCmd.MarkFlagRequired("file")
 	AddCmd.Flags().StringVarP(&description, "description", "d", "", "description for the subnet")
 	AddCmd.Flags().StringSliceVarP(&tags, "tags", "t", []string{}, "Tags to add to the subnet")
+	resultutil.AddOutputFlag(AddCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(AddCmd, &opts)
 }
 
-func Add(inputFile, subnet, description string, tags []string) error {
-	ipamData, err := os.ReadFile(inputFile)
+// Add adds subnet to inputFile and prints where it went in the given output
+// format.
+func Add(w io.Writer, inputFile, subnet, description string, tags []string, output string, opts fileutil.UpdateOptions) error {
+	err := subnetutils.CheckValidSubnet(subnet)
 	if err != nil {
-		return fmt.Errorf("error reading IPAM file: %v", err)
+		return fmt.Errorf("invalid subnet: %v", err)
 	}
-
-	var ipam models.IPAM
-	err = yaml.Unmarshal(ipamData, &ipam)
-	if err != nil {
-		return fmt.Errorf("error unmarshaling IPAM: %v", err)
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
 	}
 
-	err = subnetutils.CheckValidSubnet(subnet)
-	if err != nil {
-		return fmt.Errorf("invalid subnet: %v", err)
-	}
-	err = addsubnet(ipam.Subnets, subnet, description, tags)
+	var ipam models.IPAM
+	var result resultutil.Result
+	err = fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		if err := addsubnet(ipam.Subnets, subnet, description, tags); err != nil {
+			return fmt.Errorf("error adding subnet: %v", err)
+		}
+		result = resultutil.At(ipam.Subnets, resultutil.Added, subnet)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error adding subnet: %v", err)
+		return err
 	}
+	return resultutil.Write(w, output, []resultutil.Result{result})
+}
 
-	return fileutil.WriteYAMLAtomic(inputFile, &ipam)
+// Insert places subnet in allSubnets as Add does: under its most specific
+// existing supernet, adopting the existing subnets that fall inside it. A
+// supernet with addresses assigned in it cannot take subnets under it; that
+// is reported as a *treeutil.InUseError.
+func Insert(allSubnets map[string]models.Subnets, subnet, description string, tags []string) error {
+	return addsubnet(allSubnets, subnet, description, tags)
 }
 
 // Add a subnet to an IPAM file.
@@ -68,6 +82,9 @@ func addsubnet(allSubnets map[string]models.Subnets, subnetToAdd, description st
 			return err
 		}
 		if isSubnet {
+			if err := treeutil.CheckNestable(subnet, values); err != nil {
+				return err
+			}
 			if len(values.Subnets) == 0 {
 				values.Subnets[subnetToAdd] = models.Subnets{
 					Description: description,
@@ -92,13 +109,15 @@ func addsubnet(allSubnets map[string]models.Subnets, subnetToAdd, description st
 	return nil
 }
 
-// Re-arrange the IPAM hierarchy after adding a new subnet.
+// Re-arrange the IPAM hierarchy after adding a new subnet by moving every
+// sibling that falls inside it underneath it.
 // For example if we have:
 //
 //	prefixes:
 //		10.10.0.0/20:
 //			10.10.0.0/22:
 //				10.10.0.0/24:
+//			10.10.4.0/23:
 //
 // and we add '10.10.0.0/21', we should end up with:
 //
@@ -107,7 +126,9 @@ func addsubnet(allSubnets map[string]models.Subnets, subnetToAdd, description st
 //			10.10.0.0/21:
 //				10.10.0.0/22:
 //					10.10.0.0/24:
+//				10.10.4.0/23:
 func rearrangeSubnets(allSubnets map[string]models.Subnets, subnetToAdd string) error {
+	added := allSubnets[subnetToAdd]
 	for subnet, values := range allSubnets {
 		// Don't add subnetToAdd under itself
 		if subnet == subnetToAdd {
@@ -118,13 +139,8 @@ func rearrangeSubnets(allSubnets map[string]models.Subnets, subnetToAdd string)
 			return err
 		}
 		if isSupernet {
-			childMap := values
-			if subnets, ok := allSubnets[subnetToAdd]; ok {
-				subnetMap := subnets.Subnets
-				subnetMap[subnet] = childMap
-				delete(allSubnets, subnet)
-				return nil
-			}
+			added.Subnets[subnet] = values
+			delete(allSubnets, subnet)
 		}
 	}
 	return nil
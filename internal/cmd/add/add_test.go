@@ -1,9 +1,12 @@
 package add
 
 import (
+	"bytes"
+	"io"
 	"os"
 	"testing"
 
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
 )
 
@@ -14,7 +17,7 @@ func Test_AddSubnet(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Remove(testFile) })
 
-	if err = Add(testFile, "10.10.0.0/25", "test subnet", []string{}); err != nil {
+	if err = Add(io.Discard, testFile, "10.10.0.0/25", "test subnet", []string{}, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -40,7 +43,7 @@ func Test_AddSupernet(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Remove(testFile) })
 
-	if err = Add(testFile, "10.10.0.0/22", "test subnet", []string{}); err != nil {
+	if err = Add(io.Discard, testFile, "10.10.0.0/22", "test subnet", []string{}, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -59,6 +62,94 @@ func Test_AddSupernet(t *testing.T) {
 	}
 }
 
+// Adding a container over several existing siblings must re-parent all of
+// them, not just the first one found.
+func Test_AddSupernetAdoptsAllSiblings(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.10.0.0/20:
+        description: test subnet
+        tags: []
+        subnets:
+            10.10.0.0/24:
+                description: first
+                tags: []
+                subnets: {}
+            10.10.1.0/24:
+                description: second
+                tags: []
+                subnets: {}
+            10.10.2.0/23:
+                description: third
+                tags: []
+                subnets: {}
+            10.10.4.0/24:
+                description: outside
+                tags: []
+                subnets: {}
+`
+	testFile := "testAdoptAll.yaml"
+	if err := os.WriteFile(testFile, []byte(seed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	var out bytes.Buffer
+	if err := Add(&out, testFile, "10.10.0.0/22", "container", []string{}, "json", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantOut, err := os.ReadFile("testdata/add_supernet_adopts_all_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if out.String() != string(wantOut) {
+		t.Errorf("got output:\n%s\nwant:\n%s", out.String(), wantOut)
+	}
+
+	want, err := os.ReadFile("testdata/add_supernet_adopts_all_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_AddIPv6(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testAddIPv6.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	for _, s := range []string{"2001:db8::/48", "2001:db8::/32", "2001:db8:0:1::/64"} {
+		if err = Add(io.Discard, testFile, s, "v6 "+s, []string{}, "plain", fileutil.UpdateOptions{}); err != nil {
+			t.Fatalf("unexpected error adding %s: %v", s, err)
+		}
+	}
+
+	want, err := os.ReadFile("testdata/add_ipv6_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func Test_AddErrors(t *testing.T) {
 	testFile, err := testutils.CreateTestFile("testAddErrors.yaml")
 	if err != nil {
@@ -69,6 +160,7 @@ func Test_AddErrors(t *testing.T) {
 	tests := []struct {
 		name    string
 		subnet  string
+		output  string
 		wantErr string
 	}{
 		{
@@ -86,11 +178,26 @@ func Test_AddErrors(t *testing.T) {
 			subnet:  "10.10.0.0/20",
 			wantErr: "error adding subnet: \"10.10.0.0/20\" already exists in this IPAM file",
 		},
+		{
+			name:    "non-canonical IPv6",
+			subnet:  "2001:DB8::/32",
+			wantErr: "invalid subnet: 2001:DB8::/32 is not valid CIDR notation",
+		},
+		{
+			name:    "unknown output format",
+			subnet:  "10.10.8.0/24",
+			output:  "xml",
+			wantErr: `unknown output format "xml". Must be one of plain, json or yaml`,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := Add(testFile, tt.subnet, "test subnet", []string{})
+			output := tt.output
+			if output == "" {
+				output = "plain"
+			}
+			err := Add(io.Discard, testFile, tt.subnet, "test subnet", []string{}, output, fileutil.UpdateOptions{})
 			if err == nil {
 				t.Fatalf("expected error, got nil")
 			}
@@ -100,3 +207,38 @@ func Test_AddErrors(t *testing.T) {
 		})
 	}
 }
+
+func Test_AddUnderAssignedAddresses(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/24:
+        description: ""
+        tags: []
+        addresses:
+            10.0.0.5:
+                hostname: web-1
+        subnets: {}
+`
+	testFile := "testAddUnderAddresses.yaml"
+	if err := os.WriteFile(testFile, []byte(seed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	wantErr := "error adding subnet: 10.0.0.0/24 has address(es) assigned in it (10.0.0.5); release them before nesting subnets under it"
+	err := Add(io.Discard, testFile, "10.0.0.128/26", "", []string{}, "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if err.Error() != wantErr {
+		t.Errorf("got error %q, want %q", err.Error(), wantErr)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(got) != seed {
+		t.Errorf("file changed:\n%s", got)
+	}
+}
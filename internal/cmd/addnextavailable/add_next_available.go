@@ -1,28 +1,98 @@
 package addnextavailable
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"os"
+	"slices"
+	"strings"
 
 	"github.com/kyle-burnett/simple-ipam/internal/models"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
 	"github.com/spf13/cobra"
-	"go.yaml.in/yaml/v4"
 )
 
-var parent, description, inputFile string
-var subnetToAdd int
+var parent, name, description, inputFile, reserved, strategyName, batchFile, output string
+var subnetToAdd, hosts, count int
 var tags []string
+var opts fileutil.UpdateOptions
 
 var AddNextAvailableCmd = &cobra.Command{
-	Use:          "add-next-available",
-	Short:        "Add the next available subnet of a given length under a parent subnet",
+	Use:   "add-next-available",
+	Short: "Add the next available subnet of a given length under a parent subnet",
+	Long: `Add the next available subnet of a given length under a parent subnet.
+
+With --count several subnets of the same size are allocated under the parent.
+With --batch the requests are read from a YAML or JSON file ('-' for stdin)
+holding a list of entries like:
+
+  - parent: 10.0.0.0/16
+    prefix-length: 24
+    description: web
+    tags: [prod]
+
+Entries may also have a name, with the same meaning as --name. Either way
+the requests are applied in order and the file is written once, only if
+every one of them succeeds.
+
+With --name the allocation is idempotent: if a subnet with that name already
+exists under the parent it is reported and nothing is allocated, otherwise a
+new subnet is allocated and given the name. This makes the command safe to
+re-run from provisioning scripts and retry loops.
+
+The subnets allocated are printed one per line, or with -o json or -o yaml
+as a list giving each one's action (allocated or existing), CIDR and
+ancestor path.`,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return AddNextAvailable(inputFile, parent, description, subnetToAdd, tags)
+		strategy, err := LookupStrategy(strategyName)
+		if err != nil {
+			return err
+		}
+		if batchFile != "" {
+			requests, err := readBatchFile(cmd.InOrStdin(), batchFile)
+			if err != nil {
+				return err
+			}
+			return AddNextAvailableBatch(cmd.OutOrStdout(), inputFile, requests, strategy, output, opts)
+		}
+
+		if parent == "" {
+			return fmt.Errorf("'-p' or '--parent' is required unless '--batch' is used")
+		}
+		r := Request{Name: name, Parent: parent, PrefixLength: subnetToAdd, Description: description, Tags: tags}
+		if cmd.Flags().Changed("hosts") {
+			if hosts < 1 {
+				return fmt.Errorf("host count must be at least 1, got %d", hosts)
+			}
+			// The prefix length is worked out once the file is locked, from
+			// the reserve rules in effect at the parent unless --reserved
+			// is given.
+			r.PrefixLength, r.Hosts = 0, hosts
+			if cmd.Flags().Changed("reserved") {
+				r.Reserved = reserved
+			}
+		} else if !cmd.Flags().Changed("prefix-length") {
+			return fmt.Errorf("one of '-l', '--prefix-length' or '--hosts' is required unless '--batch' is used")
+		}
+		if count < 1 {
+			return fmt.Errorf("count must be at least 1, got %d", count)
+		}
+		if name != "" {
+			return Ensure(cmd.OutOrStdout(), inputFile, r, strategy, output, opts)
+		}
+		if count == 1 {
+			return addNext(cmd.OutOrStdout(), inputFile, r, strategy, output, opts)
+		}
+		requests := make([]Request, count)
+		for i := range requests {
+			requests[i] = r
+		}
+		return AddNextAvailableBatch(cmd.OutOrStdout(), inputFile, requests, strategy, output, opts)
 	},
 }
 
@@ -30,61 +100,222 @@ func init() {
 	AddNextAvailableCmd.Flags().IntVarP(&subnetToAdd, "prefix-length", "l", 0, "prefix length (CIDR mask bits) of the subnet to allocate")
 	AddNextAvailableCmd.Flags().StringVarP(&parent, "parent", "p", "", "Parent subnet")
 	AddNextAvailableCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
-	_ = AddNextAvailableCmd.MarkFlagRequired("prefix-length")
+	AddNextAvailableCmd.Flags().IntVar(&hosts, "hosts", 0, "number of usable host addresses needed; the smallest subnet that fits is allocated")
+	AddNextAvailableCmd.Flags().StringVar(&reserved, "reserved", DefaultReserved, "addresses reserved in each subnet when sizing by --hosts: a number or one of none, network-broadcast, aws, azure, gcp; defaults to the parent's reserve rules if it has any")
+	AddNextAvailableCmd.MarkFlagsMutuallyExclusive("prefix-length", "hosts")
 	_ = AddNextAvailableCmd.MarkFlagRequired("file")
-	_ = AddNextAvailableCmd.MarkFlagRequired("parent")
 	AddNextAvailableCmd.Flags().StringVarP(&description, "description", "d", "", "description for the subnet")
+	AddNextAvailableCmd.Flags().StringVarP(&name, "name", "n", "", "stable name for the subnet; if one with this name exists under the parent, print it instead of allocating")
 	AddNextAvailableCmd.Flags().StringSliceVarP(&tags, "tags", "t", []string{}, "Tags to add to the subnet")
+	AddNextAvailableCmd.Flags().StringVar(&strategyName, "strategy", "first-fit", "where to place the subnet: "+strings.Join(StrategyNames(), ", "))
+	AddNextAvailableCmd.Flags().IntVar(&count, "count", 1, "number of subnets to allocate")
+	AddNextAvailableCmd.MarkFlagsMutuallyExclusive("name", "count")
+	AddNextAvailableCmd.Flags().StringVar(&batchFile, "batch", "", "read allocation requests from this YAML or JSON file ('-' for stdin)")
+	for _, flag := range []string{"parent", "prefix-length", "hosts", "count", "name", "description", "tags"} {
+		AddNextAvailableCmd.MarkFlagsMutuallyExclusive("batch", flag)
+	}
+	resultutil.AddOutputFlag(AddNextAvailableCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(AddNextAvailableCmd, &opts)
 }
 
-func AddNextAvailable(inputFile, parent, description string, subnetToAdd int, tags []string) error {
-	err := subnetutils.CheckValidSubnet(parent)
+// AddNextAvailable allocates a /subnetToAdd under parent at the place
+// strategy picks, or the lowest-addressed free one if strategy is nil, and
+// prints it in the given output format.
+func AddNextAvailable(w io.Writer, inputFile, parent, description string, subnetToAdd int, tags []string, strategy Strategy, output string, opts fileutil.UpdateOptions) error {
+	r := Request{Parent: parent, PrefixLength: subnetToAdd, Description: description, Tags: tags}
+	return addNext(w, inputFile, r, strategy, output, opts)
+}
+
+// addNext allocates one subnet for r, which has no name, and prints it.
+func addNext(w io.Writer, inputFile string, r Request, strategy Strategy, output string, opts fileutil.UpdateOptions) error {
+	parentNet, err := r.Validate()
 	if err != nil {
 		return err
 	}
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+	if strategy == nil {
+		strategy = FirstFit{}
+	}
 
-	if subnetToAdd < 1 || subnetToAdd > 32 {
-		return fmt.Errorf("%v is not a valid IPv4 CIDR mask. Must be > 0 and <= 32", subnetToAdd)
+	var ipam models.IPAM
+	var result resultutil.Result
+	err = fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		result, err = allocate(ipam.Subnets, r, parentNet, strategy)
+		return err
+	})
+	if err != nil {
+		return err
 	}
+	return resultutil.Write(w, output, []resultutil.Result{result})
+}
 
-	ipamData, err := os.ReadFile(inputFile)
+// Ensure prints the subnet named r.Name under r.Parent, allocating it first
+// if it does not exist yet. The file is only written when a subnet is
+// allocated.
+func Ensure(w io.Writer, inputFile string, r Request, strategy Strategy, output string, opts fileutil.UpdateOptions) error {
+	if r.Name == "" {
+		return fmt.Errorf("a name is required")
+	}
+	parentNet, err := r.Validate()
 	if err != nil {
-		return fmt.Errorf("error reading IPAM file: %v", err)
+		return err
+	}
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+	if strategy == nil {
+		strategy = FirstFit{}
 	}
 
 	var ipam models.IPAM
-	err = yaml.Unmarshal(ipamData, &ipam)
+	var result resultutil.Result
+	err = fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		result, err = allocate(ipam.Subnets, r, parentNet, strategy)
+		if err == nil && result.Action == resultutil.Existing {
+			return errFound // nothing to write
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, errFound) {
+		return err
+	}
+	return resultutil.Write(w, output, []resultutil.Result{result})
+}
+
+// Validate checks r without looking at the IPAM data and returns its parsed
+// parent. The prefix length of a request sized by Hosts is only checked
+// once it is known.
+func (r Request) Validate() (*net.IPNet, error) {
+	err := subnetutils.CheckValidSubnet(r.Parent)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling IPAM: %v", err)
+		return nil, err
 	}
 
-	_, parentNet, err := net.ParseCIDR(parent)
+	_, parentNet, err := net.ParseCIDR(r.Parent)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if r.Hosts > 0 {
+		if r.Reserved != "" {
+			if _, err := ParseReserved(r.Reserved); err != nil {
+				return nil, err
+			}
+		}
+		return parentNet, nil
+	}
+
+	parentOnes, bits := parentNet.Mask.Size()
+	if r.PrefixLength < 1 || r.PrefixLength > bits {
+		return nil, fmt.Errorf("%v is not a valid %s CIDR mask. Must be > 0 and <= %d", r.PrefixLength, subnetutils.Family(parentNet), bits)
+	}
+	if r.PrefixLength <= parentOnes {
+		return nil, fmt.Errorf("desired prefix /%d must be longer than parent /%d and <= %d", r.PrefixLength, parentOnes, bits)
 	}
+	return parentNet, nil
+}
 
-	err = withParent(ipam.Subnets, parent, func(p *models.Subnets) error {
-		descendants, err := collectDescendants(p.Subnets)
+// Allocate places one subnet for r in allSubnets, an in-memory IPAM tree,
+// and reports the block chosen. A nil strategy means FirstFit.
+func Allocate(allSubnets map[string]models.Subnets, r Request, strategy Strategy) (resultutil.Result, error) {
+	parentNet, err := r.Validate()
+	if err != nil {
+		return resultutil.Result{}, err
+	}
+	if strategy == nil {
+		strategy = FirstFit{}
+	}
+	return allocate(allSubnets, r, parentNet, strategy)
+}
+
+// allocate places one subnet for r in allSubnets. If r is named and a
+// subnet with that name already exists, that subnet is reported instead.
+func allocate(allSubnets map[string]models.Subnets, r Request, parentNet *net.IPNet, strategy Strategy) (resultutil.Result, error) {
+	if r.Hosts > 0 {
+		var err error
+		if r.PrefixLength, err = r.prefixForHosts(allSubnets); err != nil {
+			return resultutil.Result{}, err
+		}
+	}
+	if existing, ok, err := findNamed(allSubnets, r); ok || err != nil {
 		if err != nil {
+			return resultutil.Result{}, err
+		}
+		result := resultutil.At(allSubnets, resultutil.Existing, existing.String())
+		result.Renested = []string{}
+		return result, nil
+	}
+
+	var chosen *net.IPNet
+	err := withParent(allSubnets, r.Parent, func(p *models.Subnets) error {
+		if err := treeutil.CheckNestable(r.Parent, *p); err != nil {
 			return err
 		}
-		chosen, err := findNextAvailable(parentNet, subnetToAdd, descendants)
+		descendants, err := treeutil.Nets(p.Subnets)
 		if err != nil {
 			return err
 		}
-		return insertAtDeepest(p.Subnets, chosen, models.Subnets{
-			Description: description,
+		chosen, err = strategy.Choose(parentNet, r.PrefixLength, descendants)
+		if err != nil {
+			return err
+		}
+		tags := r.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		return treeutil.InsertAtDeepest(p.Subnets, chosen, models.Subnets{
+			Name:        r.Name,
+			Description: r.Description,
 			Tags:        tags,
 			Subnets:     map[string]models.Subnets{},
 		})
 	})
 	if err != nil {
-		return err
+		return resultutil.Result{}, err
 	}
+	return resultutil.At(allSubnets, resultutil.Allocated, chosen.String()), nil
+}
 
-	return fileutil.WriteYAMLAtomic(inputFile, &ipam)
+// findNamed looks for the subnet named r.Name. A subnet with that name that
+// is not under r.Parent, or not a /r.PrefixLength, is an error rather than a
+// match: the name is taken, but not by what r asks for.
+func findNamed(allSubnets map[string]models.Subnets, r Request) (*net.IPNet, bool, error) {
+	if r.Name == "" {
+		return nil, false, nil
+	}
+	var found string
+	err := treeutil.Walk(allSubnets, func(path []string, cidr string, node models.Subnets) error {
+		if node.Name == r.Name {
+			found = cidr
+			return errFound
+		}
+		return nil
+	})
+	if !errors.Is(err, errFound) {
+		return nil, false, err
+	}
+
+	_, n, err := net.ParseCIDR(found)
+	if err != nil {
+		return nil, false, fmt.Errorf("corrupt IPAM: %q: %w", found, err)
+	}
+	inside, err := subnetutils.IsSubnetOf(r.Parent, found)
+	if err != nil {
+		return nil, false, err
+	}
+	if !inside || found == r.Parent {
+		return nil, false, fmt.Errorf("name %q is already used by %s, which is not under %s", r.Name, found, r.Parent)
+	}
+	if ones, _ := n.Mask.Size(); ones != r.PrefixLength {
+		return nil, false, fmt.Errorf("name %q is already used by %s, which is not a /%d", r.Name, found, r.PrefixLength)
+	}
+	return n, true, nil
 }
 
+// errFound stops a walk, or an update, once a named subnet has been found.
+var errFound = errors.New("found")
+
 func withParent(allSubnets map[string]models.Subnets, parentCIDR string, fn func(parent *models.Subnets) error) error {
 	for subnet, values := range allSubnets {
 		if subnet == parentCIDR {
@@ -110,106 +341,40 @@ func withParent(allSubnets map[string]models.Subnets, parentCIDR string, fn func
 	return fmt.Errorf("parent subnet %q does not exist in IPAM data", parentCIDR)
 }
 
-// collectDescendants walks the subtree rooted at tree and returns every
-// subnet it contains, parsed to *net.IPNet. Errors on any malformed CIDR key.
-func collectDescendants(tree map[string]models.Subnets) ([]*net.IPNet, error) {
-	var out []*net.IPNet
-	var walk func(m map[string]models.Subnets) error
-	walk = func(m map[string]models.Subnets) error {
-		for cidr, node := range m {
-			_, n, err := net.ParseCIDR(cidr)
-			if err != nil {
-				return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
-			}
-			out = append(out, n)
-			if err := walk(node.Subnets); err != nil {
-				return err
-			}
-		}
-		return nil
-	}
-	if err := walk(tree); err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
+// findNextAvailable returns the lowest-addressed /subnetToAdd block inside
+// parentNet that is not blocked by any descendant. Rather than testing every
+// candidate in turn (2^16 of them when carving /64s out of a /48), it walks
+// the descendants in address order and only advances the cursor past
+// candidates that one of them blocks, so the cost is bounded by the number
+// of descendants rather than the size of the parent.
 func findNextAvailable(parentNet *net.IPNet, subnetToAdd int, descendants []*net.IPNet) (*net.IPNet, error) {
 	parentNetSize, bits := parentNet.Mask.Size()
-	if bits != 32 {
-		return nil, fmt.Errorf("only IPv4 is supported")
-	}
-	if parentNetSize == 0 {
-		return nil, fmt.Errorf("/0 parents are not supported")
-	}
-	if parentNetSize >= subnetToAdd {
-		return nil, fmt.Errorf("desired prefix /%d must be longer than parent /%d and <= 32", subnetToAdd, parentNetSize)
+	if parentNetSize >= subnetToAdd || subnetToAdd > bits {
+		return nil, fmt.Errorf("desired prefix /%d must be longer than parent /%d and <= %d", subnetToAdd, parentNetSize, bits)
 	}
 
-	start := binary.BigEndian.Uint32(parentNet.IP.To4())
-	blockSize := uint32(1) << uint(32-subnetToAdd)            // addresses per candidate
-	numBlocks := uint32(1) << uint(subnetToAdd-parentNetSize) // candidates to try
-	mask := net.CIDRMask(subnetToAdd, 32)
+	sorted := slices.Clone(descendants)
+	slices.SortFunc(sorted, subnetutils.CompareNets)
 
-	for i := range numBlocks {
-		ip := make(net.IP, 4)
-		binary.BigEndian.PutUint32(ip, start+i*blockSize)
-		candidate := &net.IPNet{IP: ip, Mask: mask}
+	_, parentLast := subnetutils.NetworkBounds(parentNet)
+	blockSize := subnetutils.BlockSize(subnetToAdd, bits) // addresses per candidate
+	cursor := subnetutils.IPToInt(parentNet.IP, bits)
+	candidate := subnetutils.NetFromInt(cursor, subnetToAdd, bits)
 
-		if !candidateBlocked(candidate, subnetToAdd, descendants) {
-			return candidate, nil
-		}
-	}
-	return nil, fmt.Errorf("no available /%d subnet in %s", subnetToAdd, parentNet)
-}
-
-// candidateBlocked reports whether the candidate would displace existing
-// address space. A descendant blocks the candidate iff its range is fully
-// within (or equal to) the candidate's range — i.e., the descendant's
-// prefix is at least as long as the candidate's and its network IP falls
-// inside the candidate. Descendants that are strict supernets of the
-// candidate are not blockers; they are containers the candidate can nest
-// inside.
-func candidateBlocked(candidate *net.IPNet, candOnes int, descendants []*net.IPNet) bool {
-	for _, d := range descendants {
-		dOnes, _ := d.Mask.Size()
-		if dOnes < candOnes {
-			continue // d is bigger than candidate; it is a potential container, not a blocker
-		}
-		if candidate.Contains(d.IP) {
-			return true
-		}
-	}
-	return false
-}
-
-// insertAtDeepest inserts entry under candidate's deepest existing ancestor
-// in tree. tree is the direct-children map of the parent; candidate is
-// assumed to be free (not blocked by any descendant) and strictly inside
-// the parent's range. The CIDR invariant (at most one sibling at a given
-// level can contain a given address) guarantees only one branch matches.
-func insertAtDeepest(tree map[string]models.Subnets, candidate *net.IPNet, entry models.Subnets) error {
-	candOnes, _ := candidate.Mask.Size()
-
-	for cidr, values := range tree {
-		_, existing, err := net.ParseCIDR(cidr)
-		if err != nil {
-			return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
-		}
-		existingOnes, _ := existing.Mask.Size()
-
-		if existingOnes < candOnes && existing.Contains(candidate.IP) {
-			if values.Subnets == nil {
-				values.Subnets = make(map[string]models.Subnets)
-			}
-			if err := insertAtDeepest(values.Subnets, candidate, entry); err != nil {
-				return err
+	for _, d := range sorted {
+		if subnetutils.CandidateBlocked(candidate, subnetToAdd, []*net.IPNet{d}) {
+			// Everything d covers lies inside the candidate, so the next
+			// aligned candidate starts right after the current one.
+			cursor.Add(cursor, blockSize)
+			if cursor.Cmp(parentLast) > 0 {
+				return nil, noSpace(parentNet, subnetToAdd)
 			}
-			tree[cidr] = values // write-back for nil-init propagation
-			return nil
+			candidate = subnetutils.NetFromInt(cursor, subnetToAdd, bits)
+			continue
+		}
+		if !candidate.Contains(d.IP) && subnetutils.IPToInt(d.IP, bits).Cmp(cursor) > 0 {
+			break // descendants are sorted, so nothing later can fall inside the candidate
 		}
 	}
-
-	tree[candidate.String()] = entry
-	return nil
+	return candidate, nil
 }
@@ -1,9 +1,17 @@
 package addnextavailable
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
 )
 
@@ -45,7 +53,7 @@ func Test_AddNextAvailable_BasicAllocation(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Remove(testFile) })
 
-	if err := AddNextAvailable(testFile, "10.10.0.0/24", "first /26", 26, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.10.0.0/24", "first /26", 26, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	assertGolden(t, testFile, "testdata/basic_allocation_expected.yaml")
@@ -67,7 +75,7 @@ subnets:
 `
 	testFile := writeSeedFile(t, "testHole.yaml", seed)
 
-	if err := AddNextAvailable(testFile, "10.0.0.0/24", "hole", 26, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", "hole", 26, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	assertGolden(t, testFile, "testdata/hole_reuse_expected.yaml")
@@ -86,7 +94,7 @@ subnets:
 	testFile := writeSeedFile(t, "testFill.yaml", seed)
 
 	for i, desc := range []string{"slot 1", "slot 2", "slot 3", "slot 4"} {
-		if err := AddNextAvailable(testFile, "10.0.0.0/24", desc, 26, []string{}); err != nil {
+		if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", desc, 26, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 			t.Fatalf("iteration %d: unexpected error: %v", i, err)
 		}
 	}
@@ -109,7 +117,7 @@ subnets:
 `
 	testFile := writeSeedFile(t, "testMixed.yaml", seed)
 
-	if err := AddNextAvailable(testFile, "10.0.0.0/24", "upper half", 25, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", "upper half", 25, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	assertGolden(t, testFile, "testdata/mixed_size_overlap_expected.yaml")
@@ -135,7 +143,7 @@ subnets:
 `
 	testFile := writeSeedFile(t, "testNested.yaml", seed)
 
-	if err := AddNextAvailable(testFile, "10.0.0.0/24", "deep", 26, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", "deep", 26, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	assertGolden(t, testFile, "testdata/nested_parent_expected.yaml")
@@ -158,7 +166,7 @@ subnets:
 `
 	testFile := writeSeedFile(t, "testDescendEmpty.yaml", seed)
 
-	if err := AddNextAvailable(testFile, "10.0.0.0/24", "nested /26", 26, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", "nested /26", 26, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	assertGolden(t, testFile, "testdata/descends_into_empty_child_expected.yaml")
@@ -186,7 +194,7 @@ subnets:
 `
 	testFile := writeSeedFile(t, "testDescendPast.yaml", seed)
 
-	if err := AddNextAvailable(testFile, "10.0.0.0/24", "new /26", 26, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", "new /26", 26, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	assertGolden(t, testFile, "testdata/descends_past_grandchild_expected.yaml")
@@ -204,13 +212,13 @@ subnets:
 `
 	testFile := writeSeedFile(t, "testEdge31.yaml", seed)
 
-	if err := AddNextAvailable(testFile, "10.0.0.0/30", "first", 31, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/30", "first", 31, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if err := AddNextAvailable(testFile, "10.0.0.0/30", "second", 31, []string{}); err != nil {
+	if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/30", "second", 31, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	err := AddNextAvailable(testFile, "10.0.0.0/30", "third", 31, []string{})
+	err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/30", "third", 31, []string{}, nil, "plain", fileutil.UpdateOptions{})
 	if err == nil {
 		t.Fatalf("expected exhaustion error on third allocation, got nil")
 	}
@@ -234,17 +242,75 @@ subnets:
 	testFile := writeSeedFile(t, "testEdge32.yaml", seed)
 
 	for i := 1; i <= 4; i++ {
-		if err := AddNextAvailable(testFile, "10.0.0.0/30", "", 32, []string{}); err != nil {
+		if err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/30", "", 32, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
 			t.Fatalf("iteration %d: unexpected error: %v", i, err)
 		}
 	}
-	err := AddNextAvailable(testFile, "10.0.0.0/30", "", 32, []string{})
+	err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/30", "", 32, []string{}, nil, "plain", fileutil.UpdateOptions{})
 	if err == nil {
 		t.Fatalf("expected exhaustion error on fifth allocation, got nil")
 	}
 	assertGolden(t, testFile, "testdata/edge_prefix_32_expected.yaml")
 }
 
+// IPv6: carve a /64 out of a /48 whose first two /64s are taken, one of
+// them nested under a /56 container. Expect 2001:db8:0:2::/64 to land inside
+// the /56 next to its existing child.
+func Test_AddNextAvailable_IPv6(t *testing.T) {
+	seed := `description: ""
+subnets:
+    2001:db8::/48:
+        description: site
+        tags: []
+        subnets:
+            2001:db8::/56:
+                description: building 1
+                tags: []
+                subnets:
+                    2001:db8::/64:
+                        description: floor 1
+                        tags: []
+                        subnets: {}
+                    2001:db8:0:1::/64:
+                        description: floor 2
+                        tags: []
+                        subnets: {}
+`
+	testFile := writeSeedFile(t, "testIPv6.yaml", seed)
+
+	if err := AddNextAvailable(io.Discard, testFile, "2001:db8::/48", "floor 3", 64, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertGolden(t, testFile, "testdata/ipv6_expected.yaml")
+}
+
+// IPv6 exhaustion: a /126 holds four /128s; the fifth request must fail
+// without tripping over the end of the address space.
+func Test_AddNextAvailable_IPv6Exhaustion(t *testing.T) {
+	seed := `description: ""
+subnets:
+    ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/126:
+        description: parent
+        tags: []
+        subnets: {}
+`
+	testFile := writeSeedFile(t, "testIPv6Exhaust.yaml", seed)
+
+	for i := 1; i <= 4; i++ {
+		if err := AddNextAvailable(io.Discard, testFile, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/126", "", 128, []string{}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
+			t.Fatalf("iteration %d: unexpected error: %v", i, err)
+		}
+	}
+	err := AddNextAvailable(io.Discard, testFile, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/126", "", 128, []string{}, nil, "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected exhaustion error on fifth allocation, got nil")
+	}
+	want := "no available /128 subnet in ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/126"
+	if err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
+
 // Exhaustion: a /24 fully covered by four /26 children must reject a
 // fifth /26 request with a clean error.
 func Test_AddNextAvailable_Exhaustion(t *testing.T) {
@@ -273,7 +339,7 @@ subnets:
 `
 	testFile := writeSeedFile(t, "testExhaust.yaml", seed)
 
-	err := AddNextAvailable(testFile, "10.0.0.0/24", "", 26, []string{})
+	err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", "", 26, []string{}, nil, "plain", fileutil.UpdateOptions{})
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -283,6 +349,46 @@ subnets:
 	}
 }
 
+// Concurrent allocations against the same file must serialize on the file
+// lock, so every caller gets its own block and no write is lost.
+func Test_AddNextAvailable_Concurrent(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/24:
+        description: parent
+        tags: []
+        subnets: {}
+`
+	testFile := writeSeedFile(t, "testConcurrent.yaml", seed)
+	t.Cleanup(func() { _ = os.Remove(testFile + ".lock") })
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", fmt.Sprintf("worker %d", i), 28, []string{}, nil, "plain", fileutil.UpdateOptions{LockTimeout: 10 * time.Second})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if n := strings.Count(string(got), "/28:"); n != workers {
+		t.Errorf("got %d allocations, want %d:\n%s", n, workers, got)
+	}
+}
+
 // Table-driven error cases that share the testutils default seed
 // (10.10.0.0/20 > 10.10.0.0/24).
 func Test_AddNextAvailable_Errors(t *testing.T) {
@@ -334,11 +440,126 @@ func Test_AddNextAvailable_Errors(t *testing.T) {
 			prefix:  0,
 			wantErr: "0 is not a valid IPv4 CIDR mask. Must be > 0 and <= 32",
 		},
+		{
+			name:    "IPv6 CIDR mask too long",
+			parent:  "2001:db8::/48",
+			prefix:  129,
+			wantErr: "129 is not a valid IPv6 CIDR mask. Must be > 0 and <= 128",
+		},
+		{
+			name:    "IPv6 parent not in IPAM",
+			parent:  "2001:db8::/48",
+			prefix:  64,
+			wantErr: `parent subnet "2001:db8::/48" does not exist in IPAM data`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AddNextAvailable(io.Discard, testFile, tt.parent, "", tt.prefix, []string{}, nil, "plain", fileutil.UpdateOptions{})
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+// A parent with addresses assigned in it is a leaf that must stay one.
+func Test_AddNextAvailable_ParentHasAddresses(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/24:
+        description: ""
+        tags: []
+        addresses:
+            10.0.0.5:
+                hostname: web-1
+        subnets: {}
+`
+	testFile := writeSeedFile(t, "testParentAddresses.yaml", seed)
+
+	wantErr := "10.0.0.0/24 has address(es) assigned in it (10.0.0.5); release them before nesting subnets under it"
+	err := AddNextAvailable(io.Discard, testFile, "10.0.0.0/24", "", 26, []string{}, nil, "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if err.Error() != wantErr {
+		t.Errorf("got error %q, want %q", err.Error(), wantErr)
+	}
+}
+
+// A named allocation is made once; asking again returns the same subnet
+// without writing the file.
+func Test_Ensure(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testEnsure.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	r := Request{Name: "vpc-a-web", Parent: "10.10.0.0/24", PrefixLength: 26, Description: "web"}
+	var first bytes.Buffer
+	if err := Ensure(&first, testFile, r, nil, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "10.10.0.0/26\n"; first.String() != want {
+		t.Errorf("got %q, want %q", first.String(), want)
+	}
+	assertGolden(t, testFile, "testdata/ensure_expected.yaml")
+
+	var second bytes.Buffer
+	if err := Ensure(&second, testFile, r, nil, "json", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := os.ReadFile("testdata/ensure_existing_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if second.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", second.String(), want)
+	}
+	assertGolden(t, testFile, "testdata/ensure_expected.yaml")
+}
+
+func Test_EnsureErrors(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testEnsureErrors.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	if err := Ensure(io.Discard, testFile, Request{Name: "web", Parent: "10.10.0.0/24", PrefixLength: 26}, nil, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		request Request
+		wantErr string
+	}{
+		{
+			name:    "no name",
+			request: Request{Parent: "10.10.0.0/24", PrefixLength: 26},
+			wantErr: "a name is required",
+		},
+		{
+			name:    "name under another parent",
+			request: Request{Name: "web", Parent: "10.10.1.0/24", PrefixLength: 26},
+			wantErr: `name "web" is already used by 10.10.0.0/26, which is not under 10.10.1.0/24`,
+		},
+		{
+			name:    "name with another size",
+			request: Request{Name: "web", Parent: "10.10.0.0/24", PrefixLength: 27},
+			wantErr: `name "web" is already used by 10.10.0.0/26, which is not a /27`,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := AddNextAvailable(testFile, tt.parent, "", tt.prefix, []string{})
+			err := Ensure(io.Discard, testFile, tt.request, nil, "plain", fileutil.UpdateOptions{})
 			if err == nil {
 				t.Fatalf("expected error, got nil")
 			}
@@ -348,3 +569,129 @@ func Test_AddNextAvailable_Errors(t *testing.T) {
 		})
 	}
 }
+
+func Test_PrefixForHosts(t *testing.T) {
+	tests := []struct {
+		name     string
+		parent   string
+		hosts    int
+		reserved string
+		want     int
+	}{
+		{name: "300 hosts", parent: "10.0.0.0/16", hosts: 300, reserved: "network-broadcast", want: 23},
+		{name: "exact fit", parent: "10.0.0.0/16", hosts: 254, reserved: "network-broadcast", want: 24},
+		{name: "one over", parent: "10.0.0.0/16", hosts: 255, reserved: "network-broadcast", want: 23},
+		{name: "aws fits", parent: "10.0.0.0/16", hosts: 251, reserved: "aws", want: 24},
+		{name: "aws one over", parent: "10.0.0.0/16", hosts: 252, reserved: "aws", want: 23},
+		{name: "numeric reserved", parent: "10.0.0.0/16", hosts: 256, reserved: "0", want: 24},
+		{name: "single host", parent: "10.0.0.0/16", hosts: 1, reserved: "none", want: 32},
+		{name: "IPv6", parent: "2001:db8::/48", hosts: 1000, reserved: "none", want: 118},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PrefixForHosts(tt.parent, tt.hosts, tt.reserved)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got /%d, want /%d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_PrefixForHostsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		parent   string
+		hosts    int
+		reserved string
+		wantErr  string
+	}{
+		{
+			name:     "does not fit in parent",
+			parent:   "10.10.0.0/24",
+			hosts:    300,
+			reserved: "network-broadcast",
+			wantErr:  "300 hosts need a /23, which does not fit inside 10.10.0.0/24",
+		},
+		{
+			name:     "zero hosts",
+			parent:   "10.10.0.0/24",
+			hosts:    0,
+			reserved: "none",
+			wantErr:  "host count must be at least 1, got 0",
+		},
+		{
+			name:     "unknown preset",
+			parent:   "10.10.0.0/24",
+			hosts:    10,
+			reserved: "oracle",
+			wantErr:  `invalid reserved value "oracle". Must be a non-negative number or one of aws, azure, gcp, network-broadcast, none`,
+		},
+		{
+			name:     "more than the address family",
+			parent:   "10.0.0.0/8",
+			hosts:    1 << 32,
+			reserved: "1",
+			wantErr:  "4294967296 hosts plus 1 reserved addresses do not fit in a 32-bit address space",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PrefixForHosts(tt.parent, tt.hosts, tt.reserved)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+// A request sized by hosts takes the reserve rules in effect at its parent
+// from the tree it is allocated in, unless it gives its own.
+func Test_AllocateHosts(t *testing.T) {
+	tree := func() map[string]models.Subnets {
+		return map[string]models.Subnets{
+			"10.0.0.0/16": {
+				Reserve: []string{"first:4", "last:1"},
+				Subnets: map[string]models.Subnets{
+					"10.0.0.0/20": {Subnets: map[string]models.Subnets{}},
+					"10.0.16.0/20": {
+						Reserve: []string{"none"},
+						Subnets: map[string]models.Subnets{},
+					},
+				},
+			},
+			"10.1.0.0/16": {Subnets: map[string]models.Subnets{}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request Request
+		want    string
+	}{
+		{name: "inherited rules fit", request: Request{Parent: "10.0.0.0/20", Hosts: 251}, want: "10.0.0.0/24"},
+		{name: "inherited rules one over", request: Request{Parent: "10.0.0.0/20", Hosts: 252}, want: "10.0.0.0/23"},
+		{name: "none stops inheritance", request: Request{Parent: "10.0.16.0/20", Hosts: 256}, want: "10.0.16.0/24"},
+		{name: "no rules uses the default", request: Request{Parent: "10.1.0.0/16", Hosts: 255}, want: "10.1.0.0/23"},
+		{name: "reserved overrides rules", request: Request{Parent: "10.0.0.0/20", Hosts: 256, Reserved: "none"}, want: "10.0.0.0/24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Allocate(tree(), tt.request, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.CIDR != tt.want {
+				t.Errorf("got %s, want %s", result.CIDR, tt.want)
+			}
+		})
+	}
+}
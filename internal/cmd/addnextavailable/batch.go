@@ -0,0 +1,94 @@
+package addnextavailable
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+)
+
+// Request is one allocation in a batch.
+type Request struct {
+	Name         string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Parent       string   `yaml:"parent" json:"parent"`
+	PrefixLength int      `yaml:"prefix-length" json:"prefix-length"`
+	Description  string   `yaml:"description" json:"description"`
+	Tags         []string `yaml:"tags" json:"tags"`
+	// Hosts, if set, sizes the subnet to hold this many usable addresses
+	// instead of PrefixLength, with Reserved addresses set aside as for
+	// --reserved. An empty Reserved takes the reserve rules in effect at
+	// the parent, or DefaultReserved if there are none. They are set from
+	// the command line only.
+	Hosts    int    `yaml:"-" json:"-"`
+	Reserved string `yaml:"-" json:"-"`
+}
+
+// AddNextAvailableBatch applies requests in order to a single in-memory copy
+// of inputFile and writes it once, then prints the subnets in the given
+// output format. If any request fails nothing is written.
+func AddNextAvailableBatch(w io.Writer, inputFile string, requests []Request, strategy Strategy, output string, opts fileutil.UpdateOptions) error {
+	if len(requests) == 0 {
+		return fmt.Errorf("no allocation requests given")
+	}
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+	parents := make([]*net.IPNet, len(requests))
+	for i, r := range requests {
+		parentNet, err := r.Validate()
+		if err != nil {
+			return fmt.Errorf("request %d: %v", i+1, err)
+		}
+		parents[i] = parentNet
+	}
+	if strategy == nil {
+		strategy = FirstFit{}
+	}
+
+	var ipam models.IPAM
+	var results []resultutil.Result
+	err := fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		for i, r := range requests {
+			result, err := allocate(ipam.Subnets, r, parents[i], strategy)
+			if err != nil {
+				return fmt.Errorf("request %d: %v; nothing was written", i+1, err)
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, results)
+}
+
+// ReadBatch decodes a list of requests from YAML or JSON, rejecting unknown
+// fields so that a misspelt key is not silently ignored.
+func ReadBatch(r io.Reader) ([]Request, error) {
+	var requests []Request
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&requests); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing batch: %v", err)
+	}
+	return requests, nil
+}
+
+func readBatchFile(stdin io.Reader, path string) ([]Request, error) {
+	if path == "-" {
+		return ReadBatch(stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading batch: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	return ReadBatch(f)
+}
@@ -0,0 +1,110 @@
+package addnextavailable
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
+)
+
+func Test_AddNextAvailableBatch(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testBatch.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	// JSON is valid YAML, so ReadBatch takes either.
+	requests, err := ReadBatch(strings.NewReader(`[
+		{"parent": "10.10.0.0/24", "prefix-length": 26, "description": "web", "tags": ["prod"]},
+		{"parent": "10.10.0.0/24", "prefix-length": 26, "description": "db"},
+		{"parent": "10.10.0.0/20", "prefix-length": 24, "description": "next vpc"}
+	]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var out bytes.Buffer
+	if err := AddNextAvailableBatch(&out, testFile, requests, nil, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "10.10.0.0/26\n10.10.0.64/26\n10.10.1.0/24\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	assertGolden(t, testFile, "testdata/batch_expected.yaml")
+}
+
+func Test_AddNextAvailableBatchIsAtomic(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testBatchAtomic.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+	before, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	// Five /26s do not fit in a /24; the first four must not be written.
+	requests := make([]Request, 5)
+	for i := range requests {
+		requests[i] = Request{Parent: "10.10.0.0/24", PrefixLength: 26}
+	}
+	err = AddNextAvailableBatch(io.Discard, testFile, requests, nil, "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "request 5: no available /26 subnet in 10.10.0.0/24; nothing was written"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+
+	after, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("failed batch changed the file:\n%s", after)
+	}
+}
+
+func Test_AddNextAvailableBatchErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		batch   string
+		wantErr string
+	}{
+		{
+			name:    "misspelt field",
+			batch:   "- parent: 10.10.0.0/24\n  prefix_length: 26\n",
+			wantErr: "error parsing batch: yaml: construct errors:\n  line 2: field prefix_length not found in type addnextavailable.Request",
+		},
+		{
+			name:    "invalid request",
+			batch:   "- parent: 10.10.0.0/24\n  prefix-length: 26\n- parent: 10.10.0.0/24\n  prefix-length: 33\n",
+			wantErr: "request 2: 33 is not a valid IPv4 CIDR mask. Must be > 0 and <= 32",
+		},
+		{
+			name:    "empty",
+			batch:   "",
+			wantErr: "no allocation requests given",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, err := ReadBatch(strings.NewReader(tt.batch))
+			if err == nil {
+				err = AddNextAvailableBatch(io.Discard, "unused.yaml", requests, nil, "plain", fileutil.UpdateOptions{})
+			}
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
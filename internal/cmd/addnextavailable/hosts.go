@@ -0,0 +1,104 @@
+package addnextavailable
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+// ReservedPresets maps the names accepted by --reserved to the number of
+// addresses set aside in every subnet.
+var ReservedPresets = map[string]int{
+	"none":              0,
+	"network-broadcast": 2, // the network and broadcast addresses
+	"aws":               5, // network, VPC router, DNS, future use and broadcast
+	"azure":             5, // network, gateway, two for DNS and broadcast
+	"gcp":               4, // network, gateway, second-to-last and broadcast
+}
+
+// ParseReserved turns the value of --reserved, a preset name or a plain
+// number, into a count of addresses.
+func ParseReserved(s string) (int, error) {
+	if n, ok := ReservedPresets[s]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		names := make([]string, 0, len(ReservedPresets))
+		for name := range ReservedPresets {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return 0, fmt.Errorf("invalid reserved value %q. Must be a non-negative number or one of %s", s, strings.Join(names, ", "))
+	}
+	return n, nil
+}
+
+// DefaultReserved is how many addresses --hosts sets aside in each subnet
+// when neither --reserved nor any reserve rules say otherwise.
+const DefaultReserved = "network-broadcast"
+
+// InheritedReserved returns the number of addresses the reserve rules in
+// effect at parent set aside, as a value for --reserved. ok is false if
+// neither parent nor any of its ancestors has reserve rules, or parent is
+// not in tree, so that the --reserved default applies.
+func InheritedReserved(tree map[string]models.Subnets, parent string) (reserved string, ok bool, err error) {
+	if _, _, found := treeutil.Find(tree, parent); !found {
+		return "", false, nil
+	}
+	policy, err := addressutil.Effective(tree, parent)
+	if err != nil || !policy.Explicit {
+		return "", false, err
+	}
+	return strconv.Itoa(policy.First + policy.Last), true, nil
+}
+
+// prefixForHosts returns the prefix length of the subnet r.Hosts asks for,
+// taking the reserve rules from tree if r.Reserved is empty. It is called
+// with the file locked, so the rules cannot change before the subnet is
+// allocated.
+func (r Request) prefixForHosts(tree map[string]models.Subnets) (int, error) {
+	reserved := r.Reserved
+	if reserved == "" {
+		inherited, ok, err := InheritedReserved(tree, r.Parent)
+		if err != nil {
+			return 0, err
+		}
+		reserved = DefaultReserved
+		if ok {
+			reserved = inherited
+		}
+	}
+	return PrefixForHosts(r.Parent, r.Hosts, reserved)
+}
+
+// PrefixForHosts returns the prefix length of the smallest subnet under
+// parent that has room for hosts usable addresses, given how many addresses
+// are reserved in each subnet.
+func PrefixForHosts(parent string, hosts int, reserved string) (int, error) {
+	if err := subnetutils.CheckValidSubnet(parent); err != nil {
+		return 0, err
+	}
+	_, parentNet, _ := net.ParseCIDR(parent)
+	parentOnes, bits := parentNet.Mask.Size()
+
+	n, err := ParseReserved(reserved)
+	if err != nil {
+		return 0, err
+	}
+	ones, err := subnetutils.PrefixForHosts(hosts, n, bits)
+	if err != nil {
+		return 0, err
+	}
+	if ones <= parentOnes {
+		return 0, fmt.Errorf("%d hosts need a /%d, which does not fit inside %s", hosts, ones, parent)
+	}
+	return ones, nil
+}
@@ -0,0 +1,187 @@
+package addnextavailable
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+)
+
+// Strategy decides where under parent a new /prefix subnet is placed.
+// descendants holds every subnet already under parent, at any depth and in
+// no particular order. The chosen block must not be blocked by any of them
+// in the sense of subnetutils.CandidateBlocked; descendants larger than the
+// block are containers it will be nested inside.
+//
+// The interface lives under internal/, so Go does not allow code outside
+// this module to import it: a new strategy has to be added to this package,
+// or to another package of simple-ipam that calls RegisterStrategy.
+type Strategy interface {
+	Choose(parent *net.IPNet, prefix int, descendants []*net.IPNet) (*net.IPNet, error)
+}
+
+// FirstFit takes the lowest-addressed free block.
+type FirstFit struct{}
+
+// LastFit takes the highest-addressed free block, filling the parent from
+// the top down.
+type LastFit struct{}
+
+// BestFit takes the first block of the smallest free hole that can hold it,
+// leaving the large holes intact for large allocations. Holes are the
+// CIDR-aligned free blocks subnetutils.Subtract returns, not contiguous free
+// ranges: a free range that is not itself one aligned block is ranked as
+// several smaller holes, so BestFit can pick a block in a larger free range
+// than a true best fit would.
+type BestFit struct{}
+
+// Sparse takes the first block of the largest free hole, which spreads
+// allocations out so each has room to grow into the space after it.
+type Sparse struct{}
+
+// Random takes a free block chosen uniformly at random. Rand supplies the
+// randomness; crypto/rand is used when it is nil.
+type Random struct {
+	Rand io.Reader
+}
+
+var strategies = map[string]Strategy{
+	"first-fit": FirstFit{},
+	"last-fit":  LastFit{},
+	"best-fit":  BestFit{},
+	"sparse":    Sparse{},
+	"random":    Random{},
+}
+
+// RegisterStrategy makes s available to LookupStrategy, and so to
+// --strategy, under name. It replaces any strategy already registered with
+// that name.
+func RegisterStrategy(name string, s Strategy) {
+	strategies[name] = s
+}
+
+// LookupStrategy returns the strategy registered under name.
+func LookupStrategy(name string) (Strategy, error) {
+	if s, ok := strategies[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q. Must be one of %s", name, strings.Join(StrategyNames(), ", "))
+}
+
+// StrategyNames returns the names of the registered strategies, sorted.
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (FirstFit) Choose(parent *net.IPNet, prefix int, descendants []*net.IPNet) (*net.IPNet, error) {
+	return findNextAvailable(parent, prefix, descendants)
+}
+
+func (LastFit) Choose(parent *net.IPNet, prefix int, descendants []*net.IPNet) (*net.IPNet, error) {
+	holes := freeHoles(parent, prefix, descendants)
+	if len(holes) == 0 {
+		return nil, noSpace(parent, prefix)
+	}
+	last := holes[len(holes)-1]
+	_, bits := last.Mask.Size()
+	_, end := subnetutils.NetworkBounds(last)
+	start := end.Sub(end, subnetutils.BlockSize(prefix, bits))
+	return subnetutils.NetFromInt(start.Add(start, big.NewInt(1)), prefix, bits), nil
+}
+
+func (BestFit) Choose(parent *net.IPNet, prefix int, descendants []*net.IPNet) (*net.IPNet, error) {
+	return firstBlockOf(parent, prefix, descendants, func(a, b int) bool { return a > b })
+}
+
+func (Sparse) Choose(parent *net.IPNet, prefix int, descendants []*net.IPNet) (*net.IPNet, error) {
+	return firstBlockOf(parent, prefix, descendants, func(a, b int) bool { return a < b })
+}
+
+func (r Random) Choose(parent *net.IPNet, prefix int, descendants []*net.IPNet) (*net.IPNet, error) {
+	holes := freeHoles(parent, prefix, descendants)
+	if len(holes) == 0 {
+		return nil, noSpace(parent, prefix)
+	}
+	_, bits := parent.Mask.Size()
+
+	// Number the free blocks across all holes and pick one of the numbers.
+	total := new(big.Int)
+	for _, h := range holes {
+		ones, _ := h.Mask.Size()
+		total.Add(total, subnetutils.BlockSize(ones+bits-prefix, bits))
+	}
+	source := r.Rand
+	if source == nil {
+		source = rand.Reader
+	}
+	pick, err := rand.Int(source, total)
+	if err != nil {
+		return nil, fmt.Errorf("error choosing a random subnet: %v", err)
+	}
+
+	size := subnetutils.BlockSize(prefix, bits)
+	for _, h := range holes {
+		ones, _ := h.Mask.Size()
+		count := subnetutils.BlockSize(ones+bits-prefix, bits)
+		if pick.Cmp(count) < 0 {
+			start := subnetutils.IPToInt(h.IP, bits)
+			return subnetutils.NetFromInt(start.Add(start, pick.Mul(pick, size)), prefix, bits), nil
+		}
+		pick.Sub(pick, count)
+	}
+	return nil, noSpace(parent, prefix) // unreachable: pick < total
+}
+
+// firstBlockOf returns the first /prefix block of the free hole whose prefix
+// length better prefers over every other, taking the lowest address on ties.
+func firstBlockOf(parent *net.IPNet, prefix int, descendants []*net.IPNet, better func(a, b int) bool) (*net.IPNet, error) {
+	var chosen *net.IPNet
+	for _, h := range freeHoles(parent, prefix, descendants) {
+		hOnes, _ := h.Mask.Size()
+		if chosen == nil {
+			chosen = h
+			continue
+		}
+		if chosenOnes, _ := chosen.Mask.Size(); better(hOnes, chosenOnes) {
+			chosen = h
+		}
+	}
+	if chosen == nil {
+		return nil, noSpace(parent, prefix)
+	}
+	_, bits := chosen.Mask.Size()
+	return subnetutils.NetFromInt(subnetutils.IPToInt(chosen.IP, bits), prefix, bits), nil
+}
+
+// freeHoles returns, in address order, the aligned free blocks of parent
+// that can hold a /prefix subnet. Only descendants that would block such a
+// subnet take space away; larger ones are containers it may nest inside.
+func freeHoles(parent *net.IPNet, prefix int, descendants []*net.IPNet) []*net.IPNet {
+	var blockers []*net.IPNet
+	for _, d := range descendants {
+		if ones, _ := d.Mask.Size(); ones >= prefix {
+			blockers = append(blockers, d)
+		}
+	}
+	var holes []*net.IPNet
+	for _, h := range subnetutils.Subtract(parent, blockers) {
+		if ones, _ := h.Mask.Size(); ones <= prefix {
+			holes = append(holes, h)
+		}
+	}
+	return holes
+}
+
+func noSpace(parent *net.IPNet, prefix int) error {
+	return fmt.Errorf("no available /%d subnet in %s", prefix, parent)
+}
@@ -0,0 +1,142 @@
+package addnextavailable
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
+)
+
+func parseNets(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var out []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatalf("bad CIDR %q: %v", c, err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// The mixed layout leaves free holes of /26 at .64, /27 at .160 and /26 at
+// .192 for a /27, so the strategies land in different places.
+func Test_Strategies(t *testing.T) {
+	mixed := []string{"10.0.0.0/27", "10.0.0.32/27", "10.0.0.128/28"}
+	tests := []struct {
+		name        string
+		strategy    Strategy
+		prefix      int
+		descendants []string
+		want        string
+	}{
+		{name: "first-fit", strategy: FirstFit{}, prefix: 27, descendants: mixed, want: "10.0.0.64/27"},
+		{name: "last-fit", strategy: LastFit{}, prefix: 27, descendants: mixed, want: "10.0.0.224/27"},
+		{name: "best-fit", strategy: BestFit{}, prefix: 27, descendants: mixed, want: "10.0.0.160/27"},
+		{name: "sparse", strategy: Sparse{}, prefix: 27, descendants: mixed, want: "10.0.0.64/27"},
+		{name: "sparse spreads out", strategy: Sparse{}, prefix: 28, descendants: []string{"10.0.0.0/28"}, want: "10.0.0.128/28"},
+		{name: "containers are not holes", strategy: BestFit{}, prefix: 28, descendants: []string{"10.0.0.0/25", "10.0.0.0/28", "10.0.0.128/26"}, want: "10.0.0.16/28"},
+		{name: "last-fit empty parent", strategy: LastFit{}, prefix: 26, want: "10.0.0.192/26"},
+	}
+
+	_, parent, _ := net.ParseCIDR("10.0.0.0/24")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.strategy.Choose(parent, tt.prefix, parseNets(t, tt.descendants...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_RandomPicksFreeBlocks(t *testing.T) {
+	_, parent, _ := net.ParseCIDR("10.0.0.0/24")
+	descendants := parseNets(t, "10.0.0.0/27", "10.0.0.32/27", "10.0.0.128/28")
+
+	seen := map[string]bool{}
+	for range 200 {
+		got, err := Random{}.Choose(parent, 27, descendants)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if subnetutils.CandidateBlocked(got, 27, descendants) || !parent.Contains(got.IP) {
+			t.Fatalf("picked %s, which is not free", got)
+		}
+		seen[got.String()] = true
+	}
+	// 5 blocks are free; 200 draws missing any of them means the choice is
+	// not spread over all holes.
+	if len(seen) != 5 {
+		t.Errorf("only %d distinct blocks picked: %v", len(seen), seen)
+	}
+}
+
+func Test_StrategiesExhausted(t *testing.T) {
+	_, parent, _ := net.ParseCIDR("10.0.0.0/30")
+	descendants := parseNets(t, "10.0.0.0/31", "10.0.0.3/32")
+	for _, name := range StrategyNames() {
+		t.Run(name, func(t *testing.T) {
+			s, err := LookupStrategy(name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = s.Choose(parent, 31, descendants)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if want := "no available /31 subnet in 10.0.0.0/30"; err.Error() != want {
+				t.Errorf("got error %q, want %q", err.Error(), want)
+			}
+		})
+	}
+}
+
+// fixed always picks the same block, standing in for a library user's own
+// strategy.
+type fixed struct{ cidr string }
+
+func (f fixed) Choose(parent *net.IPNet, prefix int, descendants []*net.IPNet) (*net.IPNet, error) {
+	_, n, err := net.ParseCIDR(f.cidr)
+	return n, err
+}
+
+func Test_RegisterStrategy(t *testing.T) {
+	RegisterStrategy("fixed", fixed{cidr: "10.10.0.192/26"})
+	t.Cleanup(func() { delete(strategies, "fixed") })
+
+	s, err := LookupStrategy("fixed")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testFile, err := testutils.CreateTestFile("testRegisterStrategy.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	if err := AddNextAvailable(io.Discard, testFile, "10.10.0.0/24", "fixed", 26, []string{}, s, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertGolden(t, testFile, "testdata/custom_strategy_expected.yaml")
+}
+
+func Test_LookupStrategyUnknown(t *testing.T) {
+	_, err := LookupStrategy("worst-fit")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	want := `unknown strategy "worst-fit". Must be one of best-fit, first-fit, last-fit, random, sparse`
+	if err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,111 @@
+package apply
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/plan"
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var inputFile, planFile, output string
+var opts fileutil.UpdateOptions
+
+var ApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply a plan saved by 'plan --out'",
+	Long: `Apply a plan saved by 'plan --out' in a single atomic write.
+
+The plan records the hash of the IPAM file it was computed against. If the
+file has changed since, nothing is written and apply exits 5; run plan again
+and review the new changes.
+
+Each subnet changed is printed one per line, or with -o json or -o yaml as a
+list giving its action, CIDR and ancestor path.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Apply(cmd.OutOrStdout(), inputFile, planFile, output, opts)
+	},
+}
+
+func init() {
+	ApplyCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	ApplyCmd.Flags().StringVar(&planFile, "plan", "", "plan file written by 'plan --out'")
+	_ = ApplyCmd.MarkFlagRequired("file")
+	_ = ApplyCmd.MarkFlagRequired("plan")
+	resultutil.AddOutputFlag(ApplyCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(ApplyCmd, &opts)
+}
+
+// Apply applies the changes saved in planFile to inputFile. The file must
+// still have the hash the plan was computed against. The plan already pins
+// that hash, so opts.IfMatch, if given, only has to agree with it. The
+// subnets changed are printed in the given output format.
+func Apply(w io.Writer, inputFile, planFile, output string, opts fileutil.UpdateOptions) error {
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+	saved, err := plan.ReadFile(planFile)
+	if err != nil {
+		return err
+	}
+	if opts.IfMatch != "" && opts.IfMatch != saved.Hash {
+		return exitutil.New(exitutil.PreconditionFailed, fmt.Errorf("--if-match %s does not match the hash %s the plan was computed against", opts.IfMatch, saved.Hash))
+	}
+	opts.IfMatch = saved.Hash
+
+	var ipam models.IPAM
+	var results []resultutil.Result
+	err = fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		before := ipam.Subnets
+		if err := plan.ApplyChanges(&ipam, saved.Changes); err != nil {
+			return err
+		}
+		results = report(before, ipam.Subnets, saved.Changes)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, results)
+}
+
+// report describes changes, applied to the tree before to give after, as
+// results. A change to the file's own description concerns no subnet and is
+// left out.
+func report(before, after map[string]models.Subnets, changes []plan.Change) []resultutil.Result {
+	results := []resultutil.Result{}
+	for _, c := range changes {
+		switch c.Action {
+		case plan.Add:
+			results = append(results, resultutil.At(after, resultutil.Added, c.CIDR))
+		case plan.Allocate:
+			results = append(results, resultutil.At(after, resultutil.Allocated, c.CIDR))
+		case plan.Update:
+			r := resultutil.At(after, resultutil.Updated, c.CIDR)
+			r.Renested = []string{}
+			results = append(results, r)
+		case plan.Move:
+			r := resultutil.At(after, resultutil.Moved, c.To)
+			r.From, r.Renested = c.CIDR, []string{}
+			results = append(results, r)
+		case plan.Delete:
+			// The deleted subnet is gone from after, so its place is taken
+			// from before; its children that survive were promoted.
+			r := resultutil.At(before, resultutil.Deleted, c.CIDR)
+			r.Renested = slices.DeleteFunc(r.Renested, func(cidr string) bool {
+				_, _, ok := treeutil.Find(after, cidr)
+				return !ok
+			})
+			results = append(results, r)
+		}
+	}
+	return results
+}
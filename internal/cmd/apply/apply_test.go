@@ -0,0 +1,147 @@
+package apply
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/plan"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+)
+
+// savePlan copies the seed into a temporary directory and saves a plan for
+// it, returning the paths of both.
+func savePlan(t *testing.T) (string, string) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/seed.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading seed: %v", err)
+	}
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "ipam.yaml")
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("unexpected error writing test file: %v", err)
+	}
+	planFile := filepath.Join(dir, "plan.json")
+	if err := plan.Plan(io.Discard, testFile, "testdata/desired.yaml", "plain", planFile); err != nil {
+		t.Fatalf("unexpected error planning: %v", err)
+	}
+	return testFile, planFile
+}
+
+func Test_Apply(t *testing.T) {
+	testFile, planFile := savePlan(t)
+
+	if err := Apply(io.Discard, testFile, planFile, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/apply_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_ApplyStalePlan(t *testing.T) {
+	testFile, planFile := savePlan(t)
+
+	changed := []byte("description: edited since the plan\nsubnets: {}\n")
+	if err := os.WriteFile(testFile, changed, 0644); err != nil {
+		t.Fatalf("unexpected error writing test file: %v", err)
+	}
+
+	err := Apply(io.Discard, testFile, planFile, "plain", fileutil.UpdateOptions{})
+	var exitErr *exitutil.Error
+	if !errors.As(err, &exitErr) || exitErr.Code != exitutil.PreconditionFailed {
+		t.Fatalf("expected precondition failure, got %v", err)
+	}
+	if got, _ := os.ReadFile(testFile); string(got) != string(changed) {
+		t.Errorf("file was modified despite a stale plan:\n%s", got)
+	}
+}
+
+func Test_ApplyIfMatchDisagrees(t *testing.T) {
+	testFile, planFile := savePlan(t)
+	before, _ := os.ReadFile(testFile)
+
+	err := Apply(io.Discard, testFile, planFile, "plain", fileutil.UpdateOptions{IfMatch: "0000"})
+	var exitErr *exitutil.Error
+	if !errors.As(err, &exitErr) || exitErr.Code != exitutil.PreconditionFailed {
+		t.Fatalf("expected precondition failure, got %v", err)
+	}
+	if got, _ := os.ReadFile(testFile); string(got) != string(before) {
+		t.Errorf("file was modified despite a disagreeing --if-match:\n%s", got)
+	}
+}
+
+func Test_ApplyJSON(t *testing.T) {
+	testFile, planFile := savePlan(t)
+
+	var out bytes.Buffer
+	if err := Apply(&out, testFile, planFile, "json", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/apply_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if out.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func Test_ApplyRefusesDeletingAddresses(t *testing.T) {
+	seed := []byte(`description: ""
+subnets:
+    10.0.0.0/24:
+        description: hosts
+        tags: []
+        addresses:
+            10.0.0.4:
+                hostname: h1
+        subnets: {}
+`)
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "ipam.yaml")
+	if err := os.WriteFile(testFile, seed, 0644); err != nil {
+		t.Fatalf("unexpected error writing test file: %v", err)
+	}
+	// A plan edited by hand, so plan itself never checked the delete.
+	saved, err := json.Marshal(plan.File{
+		Hash:    fileutil.Hash(seed),
+		Changes: []plan.Change{{Action: plan.Delete, CIDR: "10.0.0.0/24"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error marshaling plan: %v", err)
+	}
+	planFile := filepath.Join(dir, "plan.json")
+	if err := os.WriteFile(planFile, saved, 0644); err != nil {
+		t.Fatalf("unexpected error writing plan: %v", err)
+	}
+
+	err = Apply(io.Discard, testFile, planFile, "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "cannot delete 10.0.0.0/24: address(es) are assigned in it (10.0.0.4); release them before deleting it"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+	if got, _ := os.ReadFile(testFile); string(got) != string(seed) {
+		t.Errorf("file was modified despite the refused delete:\n%s", got)
+	}
+}
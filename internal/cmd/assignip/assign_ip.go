@@ -0,0 +1,86 @@
+package assignip
+
+import (
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+)
+
+var subnet, ip, hostname, description, mac, inputFile, output string
+var tags []string
+var opts fileutil.UpdateOptions
+
+var AssignIPCmd = &cobra.Command{
+	Use:          "assign-ip",
+	Short:        "Assign a specific host address in a subnet",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		record, err := addressutil.Record(hostname, description, tags, mac)
+		if err != nil {
+			return err
+		}
+		return AssignIP(cmd.OutOrStdout(), inputFile, subnet, ip, record, output, opts)
+	},
+}
+
+func init() {
+	AssignIPCmd.Flags().StringVarP(&subnet, "subnet", "s", "", "leaf subnet holding the address")
+	AssignIPCmd.Flags().StringVarP(&ip, "ip", "i", "", "address to assign")
+	AssignIPCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = AssignIPCmd.MarkFlagRequired("subnet")
+	_ = AssignIPCmd.MarkFlagRequired("ip")
+	_ = AssignIPCmd.MarkFlagRequired("file")
+	AssignIPCmd.Flags().StringVar(&hostname, "hostname", "", "hostname of the host using the address")
+	AssignIPCmd.Flags().StringVarP(&description, "description", "d", "", "description for the address")
+	AssignIPCmd.Flags().StringSliceVarP(&tags, "tags", "t", []string{}, "Tags to add to the address")
+	AssignIPCmd.Flags().StringVar(&mac, "mac", "", "MAC address of the host")
+	resultutil.AddOutputFlag(AssignIPCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(AssignIPCmd, &opts)
+}
+
+// AssignIP records ip as assigned in subnet, which must be a leaf. ip must be
+// inside subnet and neither assigned already nor reserved.
+func AssignIP(w io.Writer, inputFile, subnet, ip string, record models.Address, output string, opts fileutil.UpdateOptions) error {
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+
+	var ipam models.IPAM
+	var result resultutil.Result
+	err := fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		level, n, err := addressutil.Leaf(ipam.Subnets, subnet)
+		if err != nil {
+			return err
+		}
+		parsed, err := addressutil.ParseIP(ip, n)
+		if err != nil {
+			return err
+		}
+		policy, err := addressutil.Effective(ipam.Subnets, subnet)
+		if err != nil {
+			return err
+		}
+		node := level[subnet]
+		if err := addressutil.CheckFree(n, node, policy, parsed); err != nil {
+			return err
+		}
+		if node.Addresses == nil {
+			node.Addresses = map[string]models.Address{}
+		}
+		node.Addresses[ip] = record
+		level[subnet] = node
+
+		result = resultutil.At(ipam.Subnets, resultutil.Assigned, subnet)
+		result.IP = ip
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, []resultutil.Result{result})
+}
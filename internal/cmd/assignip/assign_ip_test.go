@@ -0,0 +1,124 @@
+package assignip
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
+)
+
+func Test_AssignIP(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testAssignIP.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	record := models.Address{Hostname: "web-1", Description: "web server", Tags: []string{"prod"}, MAC: "aa:bb:cc:dd:ee:01"}
+	if err = AssignIP(io.Discard, testFile, "10.10.0.0/24", "10.10.0.10", record, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/assign_ip_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_AssignIPErrors(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testAssignIPErrors.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	if err = AssignIP(io.Discard, testFile, "10.10.0.0/24", "10.10.0.10", models.Address{Hostname: "web-1"}, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		subnet  string
+		ip      string
+		wantErr string
+	}{
+		{
+			name:    "already assigned",
+			subnet:  "10.10.0.0/24",
+			ip:      "10.10.0.10",
+			wantErr: "10.10.0.10 is already assigned to web-1",
+		},
+		{
+			name:    "network address",
+			subnet:  "10.10.0.0/24",
+			ip:      "10.10.0.0",
+			wantErr: "10.10.0.0 is reserved in 10.10.0.0/24",
+		},
+		{
+			name:    "broadcast address",
+			subnet:  "10.10.0.0/24",
+			ip:      "10.10.0.255",
+			wantErr: "10.10.0.255 is reserved in 10.10.0.0/24",
+		},
+		{
+			name:    "outside the subnet",
+			subnet:  "10.10.0.0/24",
+			ip:      "10.10.1.1",
+			wantErr: "10.10.1.1 is not inside 10.10.0.0/24",
+		},
+		{
+			name:    "other family",
+			subnet:  "10.10.0.0/24",
+			ip:      "2001:db8::1",
+			wantErr: "2001:db8::1 is not inside 10.10.0.0/24",
+		},
+		{
+			name:    "not an address",
+			subnet:  "10.10.0.0/24",
+			ip:      "10.10.0.256",
+			wantErr: `"10.10.0.256" is not a valid IP address`,
+		},
+		{
+			name:    "non-canonical",
+			subnet:  "10.10.0.0/24",
+			ip:      "::ffff:10.10.0.11",
+			wantErr: "::ffff:10.10.0.11 is not in canonical form; did you mean 10.10.0.11",
+		},
+		{
+			name:    "not a leaf",
+			subnet:  "10.10.0.0/20",
+			ip:      "10.10.1.1",
+			wantErr: "10.10.0.0/20 has subnets under it; addresses can only be assigned in a leaf subnet",
+		},
+		{
+			name:    "subnet not in IPAM",
+			subnet:  "10.20.0.0/24",
+			ip:      "10.20.0.1",
+			wantErr: `subnet "10.20.0.0/24" does not exist in IPAM data`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AssignIP(io.Discard, testFile, tt.subnet, tt.ip, models.Address{}, "plain", fileutil.UpdateOptions{})
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package assignnextip
+
+import (
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+)
+
+var subnet, hostname, description, mac, inputFile, output string
+var tags []string
+var opts fileutil.UpdateOptions
+
+var AssignNextIPCmd = &cobra.Command{
+	Use:          "assign-next-ip",
+	Short:        "Assign the lowest free host address in a subnet",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		record, err := addressutil.Record(hostname, description, tags, mac)
+		if err != nil {
+			return err
+		}
+		return AssignNextIP(cmd.OutOrStdout(), inputFile, subnet, record, output, opts)
+	},
+}
+
+func init() {
+	AssignNextIPCmd.Flags().StringVarP(&subnet, "subnet", "s", "", "leaf subnet to assign an address in")
+	AssignNextIPCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = AssignNextIPCmd.MarkFlagRequired("subnet")
+	_ = AssignNextIPCmd.MarkFlagRequired("file")
+	AssignNextIPCmd.Flags().StringVar(&hostname, "hostname", "", "hostname of the host using the address")
+	AssignNextIPCmd.Flags().StringVarP(&description, "description", "d", "", "description for the address")
+	AssignNextIPCmd.Flags().StringSliceVarP(&tags, "tags", "t", []string{}, "Tags to add to the address")
+	AssignNextIPCmd.Flags().StringVar(&mac, "mac", "", "MAC address of the host")
+	resultutil.AddOutputFlag(AssignNextIPCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(AssignNextIPCmd, &opts)
+}
+
+// AssignNextIP assigns the lowest address of subnet, which must be a leaf,
+// that is neither assigned nor reserved, and prints it.
+func AssignNextIP(w io.Writer, inputFile, subnet string, record models.Address, output string, opts fileutil.UpdateOptions) error {
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+
+	var ipam models.IPAM
+	var result resultutil.Result
+	err := fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		level, n, err := addressutil.Leaf(ipam.Subnets, subnet)
+		if err != nil {
+			return err
+		}
+		policy, err := addressutil.Effective(ipam.Subnets, subnet)
+		if err != nil {
+			return err
+		}
+		node := level[subnet]
+		ip, err := addressutil.Next(n, node, policy)
+		if err != nil {
+			return err
+		}
+		if node.Addresses == nil {
+			node.Addresses = map[string]models.Address{}
+		}
+		node.Addresses[ip.String()] = record
+		level[subnet] = node
+
+		result = resultutil.At(ipam.Subnets, resultutil.Assigned, subnet)
+		result.IP = ip.String()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, []resultutil.Result{result})
+}
@@ -0,0 +1,119 @@
+package assignnextip
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
+)
+
+func Test_AssignNextIP(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testAssignNextIP.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	var out bytes.Buffer
+	for _, host := range []string{"gw", "web-1"} {
+		if err = AssignNextIP(&out, testFile, "10.10.0.0/24", models.Address{Hostname: host}, "plain", fileutil.UpdateOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if want := "10.10.0.1\n10.10.0.2\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	want, err := os.ReadFile("testdata/assign_next_ip_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// A /30 has two usable addresses once the network and broadcast addresses
+// are set aside; a hole left by an earlier release is reused first.
+func Test_AssignNextIPExhaustion(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/30:
+        description: p2p
+        tags: []
+        addresses:
+            10.0.0.2:
+                hostname: b
+        subnets: {}
+`
+	testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(testFile, []byte(seed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := AssignNextIP(&out, testFile, "10.0.0.0/30", models.Address{Hostname: "a"}, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "10.0.0.1\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	err := AssignNextIP(io.Discard, testFile, "10.0.0.0/30", models.Address{}, "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "no free address in 10.0.0.0/30"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
+
+// 10.0.0.0/28 inherits the rules of its parent, which set aside the first
+// four addresses and the last; 10.0.0.16/28 opts out with none.
+func Test_AssignNextIPReserveRules(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/24:
+        description: vpc
+        tags: []
+        reserve:
+            - first:4
+            - last:1
+        subnets:
+            10.0.0.0/28:
+                description: inherits
+                tags: []
+                subnets: {}
+            10.0.0.16/28:
+                description: opts out
+                tags: []
+                reserve:
+                    - none
+                subnets: {}
+`
+	testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(testFile, []byte(seed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+
+	var out bytes.Buffer
+	for _, subnet := range []string{"10.0.0.0/28", "10.0.0.16/28"} {
+		if err := AssignNextIP(&out, testFile, subnet, models.Address{}, "plain", fileutil.UpdateOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if want := "10.0.0.4\n10.0.0.16\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,173 @@
+package check
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/add"
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var subnet, inputFile, output string
+
+var CheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check whether a subnet can be added, and where it would go",
+	Long: `Check whether a subnet can be added, and where it would go, without
+changing the IPAM file.
+
+The subnet is placed as 'add' would place it: under its most specific
+existing supernet, adopting the existing subnets that fall inside it. The
+command exits 6 if 'add' would refuse it: the subnet already exists, or the
+subnet it would be nested under has host addresses assigned in it.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Check(cmd.OutOrStdout(), inputFile, subnet, output)
+	},
+}
+
+func init() {
+	CheckCmd.Flags().StringVarP(&subnet, "subnet", "s", "", "subnet to check")
+	CheckCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = CheckCmd.MarkFlagRequired("subnet")
+	_ = CheckCmd.MarkFlagRequired("file")
+	CheckCmd.Flags().StringVarP(&output, "output", "o", "plain", "output format: plain or json")
+}
+
+// Verdicts of a Report. Overlaps means the subnet would be nested under one
+// that has addresses assigned, which add refuses.
+const (
+	Fits     = "fits"
+	Exists   = "exists"
+	Overlaps = "overlaps"
+)
+
+// Report is where a subnet would go if it were added.
+type Report struct {
+	CIDR    string `json:"cidr"`
+	Verdict string `json:"verdict"`
+	// Parents is the ancestor path the subnet has, or would have, outermost
+	// first.
+	Parents []string `json:"parents"`
+	// Adopts lists the existing subnets that would be re-nested under it.
+	Adopts []string `json:"adopts"`
+	// Overlaps lists the host addresses assigned in its would-be parent.
+	Overlaps []string `json:"overlaps,omitempty"`
+}
+
+// Check reports whether subnet can be added to inputFile and where it would
+// go, without writing. A conflict is returned as an error with exit code
+// exitutil.Conflict after the report is printed.
+func Check(w io.Writer, inputFile, subnet, output string) error {
+	if err := subnetutils.CheckValidSubnet(subnet); err != nil {
+		return fmt.Errorf("invalid subnet: %v", err)
+	}
+	if output != "plain" && output != "json" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain or json", output)
+	}
+
+	var ipam models.IPAM
+	if err := fileutil.ReadYAML(inputFile, &ipam); err != nil {
+		return err
+	}
+	report, err := Place(ipam.Subnets, subnet)
+	if err != nil {
+		return err
+	}
+
+	if err := write(w, report, output); err != nil {
+		return err
+	}
+	switch report.Verdict {
+	case Exists:
+		return exitutil.New(exitutil.Conflict, fmt.Errorf("%s already exists", subnet))
+	case Overlaps:
+		return exitutil.New(exitutil.Conflict, fmt.Errorf("%s would be nested under %s, which has %d address(es) assigned", subnet, report.Parents[len(report.Parents)-1], len(report.Overlaps)))
+	}
+	return nil
+}
+
+// Place works out where subnet would go in allSubnets. allSubnets is
+// modified: the subnet is inserted as add would insert it, and the verdict
+// follows from whether add.Insert accepts it.
+func Place(allSubnets map[string]models.Subnets, subnet string) (Report, error) {
+	if path, _, ok := treeutil.Find(allSubnets, subnet); ok {
+		return Report{CIDR: subnet, Verdict: Exists, Parents: orEmpty(path), Adopts: []string{}}, nil
+	}
+
+	err := add.Insert(allSubnets, subnet, "", []string{})
+	var inUse *treeutil.InUseError
+	if errors.As(err, &inUse) {
+		path, _, _ := treeutil.Find(allSubnets, inUse.Subnet)
+		return Report{
+			CIDR:     subnet,
+			Verdict:  Overlaps,
+			Parents:  append(path, inUse.Subnet),
+			Adopts:   []string{},
+			Overlaps: inUse.Addresses,
+		}, nil
+	}
+	if err != nil {
+		return Report{}, err
+	}
+	path, node, _ := treeutil.Find(allSubnets, subnet)
+	return Report{
+		CIDR:    subnet,
+		Verdict: Fits,
+		Parents: orEmpty(path),
+		Adopts:  orEmpty(treeutil.SortedCIDRs(node.Subnets)),
+	}, nil
+}
+
+func write(w io.Writer, report Report, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	var lines []string
+	switch report.Verdict {
+	case Exists:
+		lines = append(lines, report.CIDR+" already exists")
+	case Overlaps:
+		lines = append(lines, fmt.Sprintf("%s cannot be added: %s has addresses assigned: %s", report.CIDR, report.Parents[len(report.Parents)-1], strings.Join(report.Overlaps, ", ")))
+	default:
+		lines = append(lines, report.CIDR+" can be added")
+	}
+	if report.Verdict != Exists {
+		if len(report.Parents) > 0 {
+			lines = append(lines, "would be nested under "+strings.Join(report.Parents, " > "))
+		} else {
+			lines = append(lines, "would be a top-level subnet")
+		}
+		if len(report.Adopts) > 0 {
+			lines = append(lines, "would adopt "+strings.Join(report.Adopts, ", "))
+		}
+	} else if len(report.Parents) > 0 {
+		lines[0] += " under " + strings.Join(report.Parents, " > ")
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func orEmpty(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,173 @@
+package check
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/add"
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+)
+
+const seedFile = "testdata/seed.yaml"
+
+func Test_Check(t *testing.T) {
+	before, err := os.ReadFile(seedFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		subnet   string
+		want     string
+		conflict bool
+	}{
+		{
+			name:   "adopts siblings",
+			subnet: "10.20.4.0/22",
+			want: "10.20.4.0/22 can be added\n" +
+				"would be nested under 10.20.0.0/16\n" +
+				"would adopt 10.20.4.0/24, 10.20.5.0/24\n",
+		},
+		{
+			name:   "top level",
+			subnet: "192.168.0.0/24",
+			want: "192.168.0.0/24 can be added\n" +
+				"would be a top-level subnet\n",
+		},
+		{
+			name:     "already exists",
+			subnet:   "10.20.4.0/24",
+			want:     "10.20.4.0/24 already exists under 10.20.0.0/16\n",
+			conflict: true,
+		},
+		{
+			name:   "covers assigned addresses",
+			subnet: "10.20.5.0/28",
+			want: "10.20.5.0/28 cannot be added: 10.20.5.0/24 has addresses assigned: 10.20.5.9, 10.20.5.10\n" +
+				"would be nested under 10.20.0.0/16 > 10.20.5.0/24\n",
+			conflict: true,
+		},
+		{
+			name:   "under assigned addresses",
+			subnet: "10.20.5.128/25",
+			want: "10.20.5.128/25 cannot be added: 10.20.5.0/24 has addresses assigned: 10.20.5.9, 10.20.5.10\n" +
+				"would be nested under 10.20.0.0/16 > 10.20.5.0/24\n",
+			conflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			err := Check(&got, seedFile, tt.subnet, "plain")
+			if tt.conflict {
+				var exitErr *exitutil.Error
+				if !errors.As(err, &exitErr) || exitErr.Code != exitutil.Conflict {
+					t.Fatalf("expected conflict exit error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got.String(), tt.want)
+			}
+		})
+	}
+
+	after, err := os.ReadFile(seedFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("check modified the IPAM file")
+	}
+}
+
+// add accepts exactly the subnets check reports as fitting.
+func Test_CheckAgreesWithAdd(t *testing.T) {
+	seed, err := os.ReadFile(seedFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	for _, subnet := range []string{"10.20.4.0/22", "192.168.0.0/24", "10.20.4.0/24", "10.20.5.0/28", "10.20.5.128/25", "10.20.4.0/26"} {
+		t.Run(subnet, func(t *testing.T) {
+			var ipam models.IPAM
+			if err := yaml.Unmarshal(seed, &ipam); err != nil {
+				t.Fatalf("unexpected error unmarshaling seed: %v", err)
+			}
+			report, err := Place(ipam.Subnets, subnet)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+			if err := os.WriteFile(testFile, seed, 0o644); err != nil {
+				t.Fatalf("unexpected error writing test file: %v", err)
+			}
+			addErr := add.Add(io.Discard, testFile, subnet, "", []string{}, "plain", fileutil.UpdateOptions{})
+			if fits := report.Verdict == Fits; fits != (addErr == nil) {
+				t.Errorf("check says %s but add returned %v", report.Verdict, addErr)
+			}
+		})
+	}
+}
+
+func Test_CheckJSON(t *testing.T) {
+	var got bytes.Buffer
+	if err := Check(&got, seedFile, "10.20.0.0/20", "json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/check_json_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+func Test_CheckErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		output  string
+		wantErr string
+	}{
+		{
+			name:    "invalid notation",
+			subnet:  "10.20.4.1/22",
+			output:  "plain",
+			wantErr: "invalid subnet: 10.20.4.1/22 is not valid CIDR notation",
+		},
+		{
+			name:    "unknown output",
+			subnet:  "10.20.4.0/22",
+			output:  "csv",
+			wantErr: `unknown output format "csv". Must be one of plain or json`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Check(&out, seedFile, tt.subnet, tt.output)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,23 +2,26 @@ package delete
 
 import (
 	"fmt"
-	"os"
+	"io"
+	"strings"
 
 	"github.com/kyle-burnett/simple-ipam/internal/models"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
 	"github.com/spf13/cobra"
-	"go.yaml.in/yaml/v4"
 )
 
-var subnet, inputFile string
-var recursive bool
+var subnet, inputFile, output string
+var recursive, promote bool
+var opts fileutil.UpdateOptions
 
 var DeleteCmd = &cobra.Command{
 	Use:          "delete",
 	Short:        "Delete a prefix from an IPAM file",
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return Delete(inputFile, subnet, recursive)
+		return Delete(cmd.OutOrStdout(), inputFile, subnet, recursive, promote, output, opts)
 	},
 }
 
@@ -27,39 +30,78 @@ func init() {
 	DeleteCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
 	_ = DeleteCmd.MarkFlagRequired("subnet")
 	_ = DeleteCmd.MarkFlagRequired("file")
-	DeleteCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Delete a CIDR and all subnets under it")
+	DeleteCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Delete a CIDR and all subnets and addresses under it")
+	DeleteCmd.Flags().BoolVarP(&promote, "promote", "p", false, "Delete a CIDR and move the subnets under it up one level, keeping their subtrees")
+	DeleteCmd.MarkFlagsMutuallyExclusive("recursive", "promote")
+	resultutil.AddOutputFlag(DeleteCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(DeleteCmd, &opts)
 }
 
-func Delete(inputFile, subnet string, recursive bool) error {
-	ipamFile, err := os.ReadFile(inputFile)
-	if err != nil {
-		return fmt.Errorf("error reading YAML file: %v", err)
+// Delete removes subnet from inputFile. A subnet with children is only
+// removed when recursive is set, which removes the children too, or when
+// promote is set, which re-attaches them to the deleted subnet's parent.
+// A subnet with addresses assigned in it is only removed when recursive is
+// set, and the discarded addresses are reported with it.
+// What was removed is printed in the given output format; deleting a subnet
+// that does not exist changes nothing and reports nothing.
+func Delete(w io.Writer, inputFile, subnet string, recursive, promote bool, output string, opts fileutil.UpdateOptions) error {
+	if recursive && promote {
+		return fmt.Errorf("'--recursive' and '--promote' cannot be used together")
 	}
-
-	var ipam models.IPAM
-	err = yaml.Unmarshal(ipamFile, &ipam)
-	if err != nil {
-		return fmt.Errorf("error unmarshaling YAML: %v", err)
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
 	}
-
-	err = deleteCIDR(ipam.Subnets, subnet, recursive)
+	var ipam models.IPAM
+	var results []resultutil.Result
+	err := fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		path, node, ok := treeutil.Find(ipam.Subnets, subnet)
+		if !ok {
+			return nil
+		}
+		result := resultutil.Result{Action: resultutil.Deleted, CIDR: subnet, Parents: path, Renested: []string{}}
+		if result.Parents == nil {
+			result.Parents = []string{}
+		}
+		switch {
+		case promote:
+			result.Renested = append(result.Renested, treeutil.SortedCIDRs(node.Subnets)...)
+		case recursive:
+			result.Discarded = append(result.Discarded, treeutil.SortedAddresses(node.Addresses)...)
+			_ = treeutil.Walk(node.Subnets, func(path []string, cidr string, node models.Subnets) error {
+				result.Removed = append(result.Removed, cidr)
+				result.Discarded = append(result.Discarded, treeutil.SortedAddresses(node.Addresses)...)
+				return nil
+			})
+		}
+		results = append(results, result)
+		return deleteCIDR(ipam.Subnets, subnet, recursive, promote)
+	})
 	if err != nil {
 		return err
 	}
-
-	return fileutil.WriteYAMLAtomic(inputFile, &ipam)
+	return resultutil.Write(w, output, results)
 }
 
-func deleteCIDR(allSubnets map[string]models.Subnets, subnetToDelete string, recursive bool) error {
-	if _, ok := allSubnets[subnetToDelete]; ok {
-		if len(allSubnets[subnetToDelete].Subnets) > 0 && !recursive {
-			return fmt.Errorf("cannot delete %[1]s as subnets are defined under it. Use '-r' or '--recursive' to delete %[1]s and everything defined under it", subnetToDelete)
+func deleteCIDR(allSubnets map[string]models.Subnets, subnetToDelete string, recursive, promote bool) error {
+	if values, ok := allSubnets[subnetToDelete]; ok {
+		if len(values.Subnets) > 0 && !recursive && !promote {
+			return fmt.Errorf("cannot delete %[1]s as subnets are defined under it. Use '-r' or '--recursive' to delete %[1]s and everything defined under it, or '-p' or '--promote' to keep them", subnetToDelete)
+		}
+		if len(values.Addresses) > 0 && !recursive {
+			return fmt.Errorf("cannot delete %[1]s as addresses are assigned in it (%[2]s). Release them first, or use '-r' or '--recursive' to delete %[1]s and its addresses", subnetToDelete, strings.Join(treeutil.SortedAddresses(values.Addresses), ", "))
 		}
 		delete(allSubnets, subnetToDelete)
+		if promote {
+			// The children lie inside the deleted subnet, so they cannot
+			// collide with any of its former siblings.
+			for cidr, child := range values.Subnets {
+				allSubnets[cidr] = child
+			}
+		}
 		return nil
 	}
 	for _, v := range allSubnets {
-		if err := deleteCIDR(v.Subnets, subnetToDelete, recursive); err != nil {
+		if err := deleteCIDR(v.Subnets, subnetToDelete, recursive, promote); err != nil {
 			return err
 		}
 	}
@@ -1,9 +1,17 @@
 package delete
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
 	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
 )
 
@@ -14,7 +22,7 @@ func Test_Delete(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Remove(testFile) })
 
-	if err = Delete(testFile, "10.10.0.0/24", false); err != nil {
+	if err = Delete(io.Discard, testFile, "10.10.0.0/24", false, false, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -40,7 +48,7 @@ func Test_DeleteRecursive(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Remove(testFile) })
 
-	if err = Delete(testFile, "10.10.0.0/20", true); err != nil {
+	if err = Delete(io.Discard, testFile, "10.10.0.0/20", true, false, "plain", fileutil.UpdateOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -59,6 +67,41 @@ func Test_DeleteRecursive(t *testing.T) {
 	}
 }
 
+func Test_DeletePromote(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testDeletePromote.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	var out bytes.Buffer
+	if err = Delete(&out, testFile, "10.10.0.0/20", false, true, "yaml", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantOut, err := os.ReadFile("testdata/delete_promote_expected_output.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if out.String() != string(wantOut) {
+		t.Errorf("got output:\n%s\nwant:\n%s", out.String(), wantOut)
+	}
+
+	want, err := os.ReadFile("testdata/delete_promote_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func Test_DeleteNoRecursive(t *testing.T) {
 	testFile, err := testutils.CreateTestFile("testDeleteNoRecursive.yaml")
 	if err != nil {
@@ -66,8 +109,8 @@ func Test_DeleteNoRecursive(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Remove(testFile) })
 
-	wantErr := "cannot delete 10.10.0.0/20 as subnets are defined under it. Use '-r' or '--recursive' to delete 10.10.0.0/20 and everything defined under it"
-	err = Delete(testFile, "10.10.0.0/20", false)
+	wantErr := "cannot delete 10.10.0.0/20 as subnets are defined under it. Use '-r' or '--recursive' to delete 10.10.0.0/20 and everything defined under it, or '-p' or '--promote' to keep them"
+	err = Delete(io.Discard, testFile, "10.10.0.0/20", false, false, "plain", fileutil.UpdateOptions{})
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -75,3 +118,136 @@ func Test_DeleteNoRecursive(t *testing.T) {
 		t.Errorf("got error %q, want %q", err.Error(), wantErr)
 	}
 }
+
+func Test_DeleteMissing(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testDeleteMissing.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	before, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading test file: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Delete(&out, testFile, "10.20.0.0/24", false, false, "json", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "[]" {
+		t.Errorf("got output %q, want an empty list", got)
+	}
+
+	after, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading test file: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("file changed:\ngot:\n%s\nwant:\n%s", after, before)
+	}
+}
+
+const addressSeed = `description: ""
+subnets:
+    10.10.0.0/20:
+        description: test subnet
+        tags: []
+        subnets:
+            10.10.1.0/24:
+                description: hosts
+                tags: []
+                addresses:
+                    10.10.1.4:
+                        hostname: h1
+                    10.10.1.10:
+                        hostname: h2
+                subnets: {}
+`
+
+func writeAddressSeed(t *testing.T) string {
+	t.Helper()
+	testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(testFile, []byte(addressSeed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+	return testFile
+}
+
+func Test_DeleteAddressesRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		promote bool
+	}{
+		{name: "plain delete"},
+		{name: "promote", promote: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFile := writeAddressSeed(t)
+
+			err := Delete(io.Discard, testFile, "10.10.1.0/24", false, tt.promote, "plain", fileutil.UpdateOptions{})
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			wantErr := "cannot delete 10.10.1.0/24 as addresses are assigned in it (10.10.1.4, 10.10.1.10). Release them first, or use '-r' or '--recursive' to delete 10.10.1.0/24 and its addresses"
+			if err.Error() != wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), wantErr)
+			}
+
+			got, err := os.ReadFile(testFile)
+			if err != nil {
+				t.Fatalf("unexpected error reading file: %v", err)
+			}
+			if string(got) != addressSeed {
+				t.Errorf("file changed:\ngot:\n%s\nwant:\n%s", got, addressSeed)
+			}
+		})
+	}
+}
+
+func Test_DeleteAddressesRecursive(t *testing.T) {
+	tests := []struct {
+		name        string
+		subnet      string
+		wantRemoved []string
+	}{
+		{name: "the subnet holding them", subnet: "10.10.1.0/24"},
+		{name: "an ancestor", subnet: "10.10.0.0/20", wantRemoved: []string{"10.10.1.0/24"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFile := writeAddressSeed(t)
+
+			var out bytes.Buffer
+			if err := Delete(&out, testFile, tt.subnet, true, false, "json", fileutil.UpdateOptions{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var results []resultutil.Result
+			if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+				t.Fatalf("unexpected error decoding output: %v\n%s", err, out.String())
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			r := results[0]
+			if r.CIDR != tt.subnet || !slices.Equal(r.Removed, tt.wantRemoved) {
+				t.Errorf("got result %+v", r)
+			}
+			if want := []string{"10.10.1.4", "10.10.1.10"}; !slices.Equal(r.Discarded, want) {
+				t.Errorf("got discarded %v, want %v", r.Discarded, want)
+			}
+
+			got, err := os.ReadFile(testFile)
+			if err != nil {
+				t.Fatalf("unexpected error reading file: %v", err)
+			}
+			if strings.Contains(string(got), "10.10.1.4") {
+				t.Errorf("address still in file:\n%s", got)
+			}
+		})
+	}
+}
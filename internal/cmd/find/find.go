@@ -0,0 +1,210 @@
+package find
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var inputFile, output string
+var filter Filter
+
+var FindCmd = &cobra.Command{
+	Use:          "find",
+	Short:        "Find subnets by tag, description, prefix length or containment",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Find(cmd.OutOrStdout(), inputFile, filter, output)
+	},
+}
+
+func init() {
+	FindCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = FindCmd.MarkFlagRequired("file")
+	FindCmd.Flags().StringArrayVarP(&filter.Tags, "tag", "t", []string{}, "tag the subnet must have; prefix with '!' for a tag it must not have (repeatable)")
+	FindCmd.Flags().StringVar(&filter.DescriptionRegex, "description-regex", "", "regular expression the description must match")
+	FindCmd.Flags().IntVar(&filter.MinLen, "min-len", 0, "minimum prefix length")
+	FindCmd.Flags().IntVar(&filter.MaxLen, "max-len", 0, "maximum prefix length (0 for no limit)")
+	FindCmd.Flags().StringVar(&filter.Within, "within", "", "only match subnets inside (or equal to) this CIDR")
+	FindCmd.Flags().StringVar(&filter.ContainsIP, "contains-ip", "", "only match subnets containing this address")
+	FindCmd.Flags().StringVarP(&output, "output", "o", "plain", "output format: plain or json")
+}
+
+// Filter selects subnets. Every non-zero field must match.
+type Filter struct {
+	Tags             []string
+	DescriptionRegex string
+	MinLen           int
+	MaxLen           int
+	Within           string
+	ContainsIP       string
+}
+
+// Match is a subnet that passed the filter, with its ancestors outermost first.
+type Match struct {
+	CIDR        string   `json:"cidr"`
+	Path        []string `json:"path"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// matcher is a Filter with its arguments parsed.
+type matcher struct {
+	want, notWant []string
+	description   *regexp.Regexp
+	minLen        int
+	maxLen        int
+	within        *net.IPNet
+	containsIP    net.IP
+}
+
+func Find(w io.Writer, inputFile string, filter Filter, output string) error {
+	if output != "plain" && output != "json" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain or json", output)
+	}
+
+	// Check the filter first, so a bad flag is reported even if the file
+	// cannot be read.
+	m, err := newMatcher(filter)
+	if err != nil {
+		return err
+	}
+
+	var ipam models.IPAM
+	if err := fileutil.ReadYAML(inputFile, &ipam); err != nil {
+		return err
+	}
+
+	matches, err := search(ipam.Subnets, m)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(matches)
+	}
+	for _, match := range matches {
+		line := strings.Join(append(slices.Clone(match.Path), match.CIDR), " > ")
+		if match.Description != "" {
+			line += "  " + match.Description
+		}
+		if len(match.Tags) > 0 {
+			line += " [" + strings.Join(match.Tags, ", ") + "]"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search returns every subnet under allSubnets that filter accepts, in
+// numeric order.
+func Search(allSubnets map[string]models.Subnets, filter Filter) ([]Match, error) {
+	m, err := newMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+	return search(allSubnets, m)
+}
+
+func newMatcher(f Filter) (*matcher, error) {
+	m := &matcher{minLen: f.MinLen, maxLen: f.MaxLen}
+	for _, tag := range f.Tags {
+		if negated, ok := strings.CutPrefix(tag, "!"); ok {
+			m.notWant = append(m.notWant, negated)
+		} else {
+			m.want = append(m.want, tag)
+		}
+	}
+	if f.DescriptionRegex != "" {
+		re, err := regexp.Compile(f.DescriptionRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid description regex: %v", err)
+		}
+		m.description = re
+	}
+	if f.MinLen < 0 || f.MaxLen < 0 || (f.MaxLen > 0 && f.MinLen > f.MaxLen) {
+		return nil, fmt.Errorf("invalid prefix length range: min %d, max %d", f.MinLen, f.MaxLen)
+	}
+	if f.Within != "" {
+		if err := subnetutils.CheckValidSubnet(f.Within); err != nil {
+			return nil, err
+		}
+		_, m.within, _ = net.ParseCIDR(f.Within)
+	}
+	if f.ContainsIP != "" {
+		if m.containsIP = net.ParseIP(f.ContainsIP); m.containsIP == nil {
+			return nil, fmt.Errorf("%v is not a valid IP address", f.ContainsIP)
+		}
+	}
+	return m, nil
+}
+
+// search walks allSubnets in numeric order and returns every node m accepts.
+func search(allSubnets map[string]models.Subnets, m *matcher) ([]Match, error) {
+	matches := []Match{}
+	err := treeutil.Walk(allSubnets, func(path []string, cidr string, node models.Subnets) error {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		if !m.matches(n, node) {
+			return nil
+		}
+		tags := node.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		matches = append(matches, Match{
+			CIDR:        cidr,
+			Path:        append([]string{}, path...),
+			Description: node.Description,
+			Tags:        tags,
+		})
+		return nil
+	})
+	return matches, err
+}
+
+func (m *matcher) matches(n *net.IPNet, node models.Subnets) bool {
+	for _, tag := range m.want {
+		if !slices.Contains(node.Tags, tag) {
+			return false
+		}
+	}
+	for _, tag := range m.notWant {
+		if slices.Contains(node.Tags, tag) {
+			return false
+		}
+	}
+	if m.description != nil && !m.description.MatchString(node.Description) {
+		return false
+	}
+	ones, _ := n.Mask.Size()
+	if ones < m.minLen || (m.maxLen > 0 && ones > m.maxLen) {
+		return false
+	}
+	if m.within != nil {
+		if withinOnes, _ := m.within.Mask.Size(); ones < withinOnes || !m.within.Contains(n.IP) {
+			return false
+		}
+	}
+	if m.containsIP != nil && !n.Contains(m.containsIP) {
+		return false
+	}
+	return true
+}
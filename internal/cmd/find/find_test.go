@@ -0,0 +1,147 @@
+package find
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const seedFile = "testdata/seed.yaml"
+
+func Test_Find(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{
+			name:   "prod under us-east",
+			filter: Filter{Tags: []string{"prod"}, Within: "10.1.0.0/16"},
+			want: "10.0.0.0/8 > 10.1.0.0/16 > 10.1.0.0/24  us-east web [prod, web]\n" +
+				"10.0.0.0/8 > 10.1.0.0/16 > 10.1.1.0/24  us-east legacy db [prod, legacy]\n",
+		},
+		{
+			name:   "negated tag",
+			filter: Filter{Tags: []string{"prod", "!legacy"}},
+			want: "10.0.0.0/8 > 10.1.0.0/16 > 10.1.0.0/24  us-east web [prod, web]\n" +
+				"10.0.0.0/8 > 10.2.0.0/16 > 10.2.0.0/24  us-west web [prod, web]\n" +
+				"2001:db8::/32  v6 [prod]\n",
+		},
+		{
+			name:   "description regex",
+			filter: Filter{DescriptionRegex: "^us-(east|west)$"},
+			want: "10.0.0.0/8 > 10.1.0.0/16  us-east [region]\n" +
+				"10.0.0.0/8 > 10.2.0.0/16  us-west [region]\n",
+		},
+		{
+			name:   "prefix length range",
+			filter: Filter{MinLen: 9, MaxLen: 16},
+			want: "10.0.0.0/8 > 10.1.0.0/16  us-east [region]\n" +
+				"10.0.0.0/8 > 10.2.0.0/16  us-west [region]\n",
+		},
+		{
+			name:   "contains ip",
+			filter: Filter{ContainsIP: "10.1.2.3"},
+			want: "10.0.0.0/8  corp\n" +
+				"10.0.0.0/8 > 10.1.0.0/16  us-east [region]\n" +
+				"10.0.0.0/8 > 10.1.0.0/16 > 10.1.2.0/24  us-east staging [staging]\n",
+		},
+		{
+			name:   "no matches",
+			filter: Filter{Tags: []string{"missing"}},
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := Find(&got, seedFile, tt.filter, "plain"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_FindJSON(t *testing.T) {
+	var got bytes.Buffer
+	if err := Find(&got, seedFile, Filter{Tags: []string{"web"}, Within: "10.2.0.0/16"}, "json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/find_json_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+func Test_FindErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		output  string
+		wantErr string
+	}{
+		{
+			name:    "unknown output",
+			output:  "csv",
+			wantErr: `unknown output format "csv". Must be one of plain or json`,
+		},
+		{
+			name:    "bad regex",
+			filter:  Filter{DescriptionRegex: "("},
+			output:  "plain",
+			wantErr: "invalid description regex: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name:    "inverted length range",
+			filter:  Filter{MinLen: 24, MaxLen: 16},
+			output:  "plain",
+			wantErr: "invalid prefix length range: min 24, max 16",
+		},
+		{
+			name:    "non-canonical within",
+			filter:  Filter{Within: "10.1.0.1/16"},
+			output:  "plain",
+			wantErr: "10.1.0.1/16 is not valid CIDR notation",
+		},
+		{
+			name:    "bad ip",
+			filter:  Filter{ContainsIP: "10.1.2"},
+			output:  "plain",
+			wantErr: "10.1.2 is not a valid IP address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Find(&out, seedFile, tt.filter, tt.output)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_FindChecksFilterBeforeReading(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	err := Find(io.Discard, missing, Filter{DescriptionRegex: "("}, "plain")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "invalid description regex: error parsing regexp: missing closing ): `(`"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
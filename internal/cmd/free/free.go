@@ -0,0 +1,130 @@
+package free
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var parent, inputFile, output string
+var prefixLength int
+
+var FreeCmd = &cobra.Command{
+	Use:          "free",
+	Short:        "List the unallocated blocks under a parent subnet",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Free(cmd.OutOrStdout(), inputFile, parent, prefixLength, output)
+	},
+}
+
+func init() {
+	FreeCmd.Flags().StringVarP(&parent, "parent", "p", "", "Parent subnet")
+	FreeCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = FreeCmd.MarkFlagRequired("parent")
+	_ = FreeCmd.MarkFlagRequired("file")
+	FreeCmd.Flags().IntVarP(&prefixLength, "prefix-length", "l", 0, "only list free blocks big enough to hold a subnet of this prefix length (0 for all)")
+	FreeCmd.Flags().StringVarP(&output, "output", "o", "plain", "output format: plain or json")
+}
+
+// Block is one free CIDR.
+type Block struct {
+	CIDR string   `json:"cidr"`
+	Size *big.Int `json:"size"`
+}
+
+// Report is the free space under a parent.
+type Report struct {
+	Parent      string   `json:"parent"`
+	Size        *big.Int `json:"size"`
+	Free        []Block  `json:"free"`
+	TotalFree   *big.Int `json:"total_free"`
+	PercentFree float64  `json:"percent_free"`
+}
+
+func Free(w io.Writer, inputFile, parent string, prefixLength int, output string) error {
+	if output != "plain" && output != "json" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain or json", output)
+	}
+
+	err := subnetutils.CheckValidSubnet(parent)
+	if err != nil {
+		return err
+	}
+	_, parentNet, err := net.ParseCIDR(parent)
+	if err != nil {
+		return err
+	}
+	if _, bits := parentNet.Mask.Size(); prefixLength < 0 || prefixLength > bits {
+		return fmt.Errorf("%v is not a valid %s CIDR mask. Must be >= 0 and <= %d", prefixLength, subnetutils.Family(parentNet), bits)
+	}
+
+	var ipam models.IPAM
+	if err := fileutil.ReadYAML(inputFile, &ipam); err != nil {
+		return err
+	}
+
+	_, node, ok := treeutil.Find(ipam.Subnets, parent)
+	if !ok {
+		return fmt.Errorf("parent subnet %q does not exist in IPAM data", parent)
+	}
+
+	report, err := freeSpace(parentNet, node.Subnets, prefixLength)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	for _, b := range report.Free {
+		if _, err := fmt.Fprintf(w, "%s (%s addresses)\n", b.CIDR, b.Size); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "Total: %d blocks, %s of %s addresses free (%.2f%%)\n", len(report.Free), report.TotalFree, report.Size, report.PercentFree)
+	return err
+}
+
+// freeSpace reports the space in parentNet that none of its direct children
+// cover. Space inside a child belongs to that child even if the child has
+// no subnets of its own; run free against the child to see it. Blocks too
+// small to hold a /prefixLength are left out of the report and its totals.
+func freeSpace(parentNet *net.IPNet, children map[string]models.Subnets, prefixLength int) (*Report, error) {
+	var used []*net.IPNet
+	for cidr := range children {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		used = append(used, n)
+	}
+
+	report := &Report{
+		Parent:    parentNet.String(),
+		Size:      subnetutils.NetworkSize(parentNet),
+		Free:      []Block{},
+		TotalFree: new(big.Int),
+	}
+	for _, n := range subnetutils.Subtract(parentNet, used) {
+		if ones, _ := n.Mask.Size(); prefixLength > 0 && ones > prefixLength {
+			continue
+		}
+		size := subnetutils.NetworkSize(n)
+		report.Free = append(report.Free, Block{CIDR: n.String(), Size: size})
+		report.TotalFree.Add(report.TotalFree, size)
+	}
+	report.PercentFree = subnetutils.Percent(report.TotalFree, report.Size)
+	return report, nil
+}
@@ -0,0 +1,115 @@
+package free
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+const seedFile = "testdata/seed.yaml"
+
+func Test_Free(t *testing.T) {
+	tests := []struct {
+		name         string
+		parent       string
+		prefixLength int
+		want         string
+	}{
+		{
+			name:   "holes around children",
+			parent: "10.0.0.0/24",
+			want: "10.0.0.0/26 (64 addresses)\n" +
+				"10.0.0.128/25 (128 addresses)\n" +
+				"Total: 2 blocks, 192 of 256 addresses free (75.00%)\n",
+		},
+		{
+			name:         "minimum block size",
+			parent:       "10.0.0.0/16",
+			prefixLength: 20,
+			want: "10.0.16.0/20 (4096 addresses)\n" +
+				"10.0.32.0/19 (8192 addresses)\n" +
+				"10.0.64.0/18 (16384 addresses)\n" +
+				"Total: 3 blocks, 28672 of 65536 addresses free (43.75%)\n",
+		},
+		{
+			name:   "empty child is entirely free",
+			parent: "10.0.3.0/24",
+			want: "10.0.3.0/24 (256 addresses)\n" +
+				"Total: 1 blocks, 256 of 256 addresses free (100.00%)\n",
+		},
+		{
+			name:         "IPv6",
+			parent:       "2001:db8::/48",
+			prefixLength: 50,
+			want: "2001:db8:0:4000::/50 (302231454903657293676544 addresses)\n" +
+				"2001:db8:0:8000::/49 (604462909807314587353088 addresses)\n" +
+				"Total: 2 blocks, 906694364710971881029632 of 1208925819614629174706176 addresses free (75.00%)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := Free(&got, seedFile, tt.parent, tt.prefixLength, "plain"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_FreeJSON(t *testing.T) {
+	var got bytes.Buffer
+	if err := Free(&got, seedFile, "10.0.0.0/24", 0, "json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/free_json_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+func Test_FreeErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		parent       string
+		prefixLength int
+		wantErr      string
+	}{
+		{
+			name:    "parent not in IPAM",
+			parent:  "192.168.0.0/24",
+			wantErr: `parent subnet "192.168.0.0/24" does not exist in IPAM data`,
+		},
+		{
+			name:    "non-canonical parent",
+			parent:  "10.0.0.1/24",
+			wantErr: "10.0.0.1/24 is not valid CIDR notation",
+		},
+		{
+			name:         "prefix length too long",
+			parent:       "10.0.0.0/24",
+			prefixLength: 33,
+			wantErr:      "33 is not a valid IPv4 CIDR mask. Must be >= 0 and <= 32",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Free(&out, seedFile, tt.parent, tt.prefixLength, "plain")
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
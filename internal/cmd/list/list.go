@@ -0,0 +1,210 @@
+package list
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var inputFile, under, output string
+var depth int
+
+var ListCmd = &cobra.Command{
+	Use:          "list",
+	Aliases:      []string{"show"},
+	Short:        "Print the subnets in an IPAM file",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return List(cmd.OutOrStdout(), inputFile, under, depth, output)
+	},
+}
+
+func init() {
+	ListCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = ListCmd.MarkFlagRequired("file")
+	ListCmd.Flags().StringVarP(&under, "under", "u", "", "only list this subnet and the subnets under it")
+	ListCmd.Flags().IntVar(&depth, "depth", 0, "maximum number of levels to print (0 for no limit)")
+	ListCmd.Flags().StringVarP(&output, "output", "o", "tree", "output format: tree, table, json or csv")
+}
+
+// row is one subnet in the listing.
+type row struct {
+	CIDR        string   `json:"cidr"`
+	Parent      string   `json:"parent"`
+	Depth       int      `json:"depth"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Size        *big.Int `json:"size"`
+	Children    int      `json:"children"`
+}
+
+func List(w io.Writer, inputFile, under string, depth int, output string) error {
+	if depth < 0 {
+		return fmt.Errorf("depth must be >= 0")
+	}
+
+	var ipam models.IPAM
+	if err := fileutil.ReadYAML(inputFile, &ipam); err != nil {
+		return err
+	}
+
+	rows, err := collectRows(ipam.Subnets, under, depth)
+	if err != nil {
+		return err
+	}
+
+	switch output {
+	case "tree":
+		return writeTree(w, rows)
+	case "table":
+		return writeTable(w, rows)
+	case "json":
+		return writeJSON(w, rows)
+	case "csv":
+		return writeCSV(w, rows)
+	default:
+		return fmt.Errorf("unknown output format %q. Must be one of tree, table, json or csv", output)
+	}
+}
+
+// collectRows flattens the tree (or the subtree rooted at under) into rows in
+// numeric address order, stopping depth levels below the starting point.
+func collectRows(allSubnets map[string]models.Subnets, under string, depth int) ([]row, error) {
+	roots := allSubnets
+	rootParent := ""
+	if under != "" {
+		if err := subnetutils.CheckValidSubnet(under); err != nil {
+			return nil, err
+		}
+		path, node, ok := treeutil.Find(allSubnets, under)
+		if !ok {
+			return nil, fmt.Errorf("subnet %q does not exist in IPAM data", under)
+		}
+		roots = map[string]models.Subnets{under: node}
+		if len(path) > 0 {
+			rootParent = path[len(path)-1]
+		}
+	}
+
+	rows := []row{}
+	err := treeutil.Walk(roots, func(path []string, cidr string, node models.Subnets) error {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		r := row{
+			CIDR:        cidr,
+			Depth:       len(path),
+			Description: node.Description,
+			Tags:        node.Tags,
+			Size:        subnetutils.NetworkSize(n),
+			Children:    len(node.Subnets),
+			Parent:      rootParent,
+		}
+		if r.Tags == nil {
+			r.Tags = []string{}
+		}
+		if len(path) > 0 {
+			r.Parent = path[len(path)-1]
+		}
+		rows = append(rows, r)
+		if depth > 0 && len(path)+1 >= depth {
+			return treeutil.SkipChildren
+		}
+		return nil
+	})
+	return rows, err
+}
+
+func writeTree(w io.Writer, rows []row) error {
+	for i, r := range rows {
+		prefix := ""
+		for d := 1; d <= r.Depth; d++ {
+			more := hasLaterSibling(rows[i+1:], d)
+			switch {
+			case d == r.Depth && more:
+				prefix += "├── "
+			case d == r.Depth:
+				prefix += "└── "
+			case more:
+				prefix += "│   "
+			default:
+				prefix += "    "
+			}
+		}
+		line := prefix + r.CIDR
+		if r.Description != "" {
+			line += " " + r.Description
+		}
+		if len(r.Tags) > 0 {
+			line += " [" + strings.Join(r.Tags, ", ") + "]"
+		}
+		line += fmt.Sprintf(" (%s addresses, %s)", r.Size, plural(r.Children, "child", "children"))
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasLaterSibling reports whether another node at depth d follows before the
+// listing climbs back above d, i.e. whether the branch at d continues.
+func hasLaterSibling(rest []row, d int) bool {
+	for _, r := range rest {
+		if r.Depth < d {
+			return false
+		}
+		if r.Depth == d {
+			return true
+		}
+	}
+	return false
+}
+
+func writeTable(w io.Writer, rows []row) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "CIDR\tPARENT\tDEPTH\tSIZE\tCHILDREN\tDESCRIPTION\tTAGS"); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%s\t%s\n", r.CIDR, r.Parent, r.Depth, r.Size, r.Children, r.Description, strings.Join(r.Tags, ",")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, rows []row) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+func writeCSV(w io.Writer, rows []row) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{"cidr", "parent", "depth", "description", "tags", "size", "children"}}
+	for _, r := range rows {
+		records = append(records, []string{r.CIDR, r.Parent, strconv.Itoa(r.Depth), r.Description, strings.Join(r.Tags, ";"), r.Size.String(), strconv.Itoa(r.Children)})
+	}
+	return cw.WriteAll(records)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, one)
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
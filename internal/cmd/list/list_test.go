@@ -0,0 +1,91 @@
+package list
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// The seed holds 10.10.0.0/20 and 10.2.0.0/16 so that lexicographic and
+// numeric order disagree, plus an IPv6 prefix that must sort after them.
+const seedFile = "testdata/seed.yaml"
+
+func Test_List(t *testing.T) {
+	tests := []struct {
+		name   string
+		under  string
+		depth  int
+		output string
+		golden string
+	}{
+		{name: "tree", output: "tree", golden: "testdata/tree_expected.txt"},
+		{name: "table", output: "table", golden: "testdata/table_expected.txt"},
+		{name: "json", output: "json", golden: "testdata/json_expected.json"},
+		{name: "csv", output: "csv", golden: "testdata/csv_expected.csv"},
+		{name: "depth", depth: 2, output: "tree", golden: "testdata/depth_expected.txt"},
+		{name: "under", under: "10.10.0.0/24", output: "table", golden: "testdata/under_expected.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := List(&got, seedFile, tt.under, tt.depth, tt.output); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, err := os.ReadFile(tt.golden)
+			if err != nil {
+				t.Fatalf("unexpected error reading fixture: %v", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+			}
+		})
+	}
+}
+
+func Test_ListErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		under   string
+		depth   int
+		output  string
+		wantErr string
+	}{
+		{
+			name:    "unknown output",
+			output:  "xml",
+			wantErr: `unknown output format "xml". Must be one of tree, table, json or csv`,
+		},
+		{
+			name:    "negative depth",
+			depth:   -1,
+			output:  "tree",
+			wantErr: "depth must be >= 0",
+		},
+		{
+			name:    "under not in IPAM",
+			under:   "192.168.0.0/24",
+			output:  "tree",
+			wantErr: `subnet "192.168.0.0/24" does not exist in IPAM data`,
+		},
+		{
+			name:    "under non-canonical",
+			under:   "10.10.0.1/24",
+			output:  "tree",
+			wantErr: "10.10.0.1/24 is not valid CIDR notation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := List(&out, seedFile, tt.under, tt.depth, tt.output)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
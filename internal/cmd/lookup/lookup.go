@@ -0,0 +1,169 @@
+package lookup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var inputFile, output string
+
+var LookupCmd = &cobra.Command{
+	Use:   "lookup [ip...]",
+	Short: "Show the subnets containing one or more IP addresses",
+	Long: `Show the subnets containing one or more IP addresses, from the top-level
+subnet down to the most specific one, with their descriptions and tags.
+
+The addresses are taken from the arguments or, if there are none, from stdin,
+one per line. The command exits 1 if any address is not valid or not in any
+subnet, after reporting on every address.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ips := args
+		if len(ips) == 0 {
+			var err error
+			if ips, err = readIPs(cmd.InOrStdin()); err != nil {
+				return err
+			}
+		}
+		return Lookup(cmd.OutOrStdout(), inputFile, ips, output)
+	},
+}
+
+func init() {
+	LookupCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = LookupCmd.MarkFlagRequired("file")
+	LookupCmd.Flags().StringVarP(&output, "output", "o", "plain", "output format: plain or json")
+}
+
+// Hop is one subnet of the chain containing an address.
+type Hop struct {
+	CIDR        string   `json:"cidr"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// Result is the chain of subnets containing an address, outermost first. It
+// is empty if no subnet contains the address, or if the address is not
+// valid, in which case Error says so.
+type Result struct {
+	IP    string `json:"ip"`
+	Chain []Hop  `json:"chain"`
+	Error string `json:"error,omitempty"`
+}
+
+// notValid is the Error of a Result for input that is not an IP address.
+const notValid = "not a valid IP address"
+
+func Lookup(w io.Writer, inputFile string, ips []string, output string) error {
+	if output != "plain" && output != "json" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain or json", output)
+	}
+	var ipam models.IPAM
+	if err := fileutil.ReadYAML(inputFile, &ipam); err != nil {
+		return err
+	}
+	ix, err := treeutil.NewIndex(ipam.Subnets)
+	if err != nil {
+		return err
+	}
+
+	results := make([]Result, len(ips))
+	var invalid, missing int
+	for i, ip := range ips {
+		results[i] = Result{IP: ip, Chain: []Hop{}}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			results[i].Error = notValid
+			invalid++
+			continue
+		}
+		for _, e := range ix.Lookup(parsed) {
+			tags := e.Node.Tags
+			if tags == nil {
+				tags = []string{}
+			}
+			results[i].Chain = append(results[i].Chain, Hop{CIDR: e.CIDR, Description: e.Node.Description, Tags: tags})
+		}
+		if len(results[i].Chain) == 0 {
+			missing++
+		}
+	}
+
+	if err := write(w, results, output); err != nil {
+		return err
+	}
+	switch {
+	case invalid > 0 && missing > 0:
+		return fmt.Errorf("%d of %d address(es) are not valid IP addresses and %d are not in any subnet", invalid, len(ips), missing)
+	case invalid > 0:
+		return fmt.Errorf("%d of %d address(es) are not valid IP addresses", invalid, len(ips))
+	case missing > 0:
+		return fmt.Errorf("%d of %d address(es) are not in any subnet", missing, len(ips))
+	}
+	return nil
+}
+
+// readIPs reads one address per line from r, skipping blank lines.
+func readIPs(r io.Reader) ([]string, error) {
+	var ips []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			ips = append(ips, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading addresses: %v", err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses given")
+	}
+	return ips, nil
+}
+
+func write(w io.Writer, results []Result, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, r := range results {
+		if _, err := fmt.Fprintln(w, r.IP); err != nil {
+			return err
+		}
+		switch {
+		case r.Error != "":
+			if _, err := fmt.Fprintln(w, "    "+r.Error); err != nil {
+				return err
+			}
+		case len(r.Chain) == 0:
+			if _, err := fmt.Fprintln(w, "    not in any subnet"); err != nil {
+				return err
+			}
+		}
+		for _, hop := range r.Chain {
+			line := "    " + hop.CIDR
+			if hop.Description != "" {
+				line += "  " + hop.Description
+			}
+			if len(hop.Tags) > 0 {
+				line += " [" + strings.Join(hop.Tags, ", ") + "]"
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package lookup
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const seedFile = "testdata/seed.yaml"
+
+func Test_Lookup(t *testing.T) {
+	var got bytes.Buffer
+	if err := Lookup(&got, seedFile, []string{"10.1.2.3", "10.2.0.255", "2001:db8::1"}, "plain"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/lookup_expected.txt")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+// An address outside every subnet is reported with an empty chain, and the
+// command fails once the others have been printed.
+func Test_LookupJSONMissing(t *testing.T) {
+	var got bytes.Buffer
+	err := Lookup(&got, seedFile, []string{"10.200.0.1", "192.168.0.1"}, "json")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "1 of 2 address(es) are not in any subnet"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+
+	want, err := os.ReadFile("testdata/lookup_json_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+// Input that is not an address is reported in place, like an address that
+// is in no subnet, and the rest are still looked up.
+func Test_LookupInvalid(t *testing.T) {
+	var got bytes.Buffer
+	err := Lookup(&got, seedFile, []string{"10.1.2", "10.1.2.3", "192.168.0.1"}, "plain")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "1 of 3 address(es) are not valid IP addresses and 1 are not in any subnet"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+
+	want := "10.1.2\n" +
+		"    not a valid IP address\n" +
+		"10.1.2.3\n" +
+		"    10.0.0.0/8  corp\n" +
+		"    10.1.0.0/16  us-east [region]\n" +
+		"    10.1.2.0/24  us-east staging [staging]\n" +
+		"192.168.0.1\n" +
+		"    not in any subnet\n"
+	if got.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+func Test_LookupInvalidJSON(t *testing.T) {
+	var got bytes.Buffer
+	err := Lookup(&got, seedFile, []string{"not-an-ip"}, "json")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "1 of 1 address(es) are not valid IP addresses"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+
+	var results []Result
+	if err := json.Unmarshal(got.Bytes(), &results); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, got.String())
+	}
+	if len(results) != 1 || results[0].IP != "not-an-ip" || len(results[0].Chain) != 0 || results[0].Error != "not a valid IP address" {
+		t.Errorf("got %+v, want one result for not-an-ip with an error", results)
+	}
+}
+
+func Test_ReadIPs(t *testing.T) {
+	got, err := readIPs(strings.NewReader("10.1.2.3\n\n  10.2.0.1 \n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"10.1.2.3", "10.2.0.1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := readIPs(strings.NewReader("\n")); err == nil || err.Error() != "no addresses given" {
+		t.Errorf("got error %v, want %q", err, "no addresses given")
+	}
+}
+
+func Test_LookupErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ips     []string
+		output  string
+		wantErr string
+	}{
+		{
+			name:    "unknown output",
+			ips:     []string{"10.1.2.3"},
+			output:  "csv",
+			wantErr: `unknown output format "csv". Must be one of plain or json`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Lookup(&out, seedFile, tt.ips, tt.output)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
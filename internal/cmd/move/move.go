@@ -0,0 +1,166 @@
+package move
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var from, to, inputFile, output string
+var opts fileutil.UpdateOptions
+
+var MoveCmd = &cobra.Command{
+	Use:          "move",
+	Short:        "Renumber a subnet and everything under it into new address space",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Move(cmd.OutOrStdout(), inputFile, from, to, output, opts)
+	},
+}
+
+func init() {
+	MoveCmd.Flags().StringVar(&from, "from", "", "subnet to move")
+	MoveCmd.Flags().StringVar(&to, "to", "", "destination subnet, with the same prefix length as --from")
+	MoveCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = MoveCmd.MarkFlagRequired("from")
+	_ = MoveCmd.MarkFlagRequired("to")
+	_ = MoveCmd.MarkFlagRequired("file")
+	resultutil.AddOutputFlag(MoveCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(MoveCmd, &opts)
+}
+
+// Mapping is the new CIDR a moved subnet is given.
+type Mapping struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Move renumbers from and every subnet under it into to, shifting each by the
+// same offset and keeping descriptions and tags. to must have the same prefix
+// length as from and must not overlap anything already allocated, other than
+// subnets large enough to contain it. Each subnet moved is printed in the
+// given output format with its old and new CIDR.
+func Move(w io.Writer, inputFile, from, to, output string, opts fileutil.UpdateOptions) error {
+	for _, s := range []string{from, to} {
+		if err := subnetutils.CheckValidSubnet(s); err != nil {
+			return fmt.Errorf("invalid subnet: %v", err)
+		}
+	}
+	_, fromNet, _ := net.ParseCIDR(from)
+	_, toNet, _ := net.ParseCIDR(to)
+	fromOnes, fromBits := fromNet.Mask.Size()
+	toOnes, toBits := toNet.Mask.Size()
+	if fromOnes != toOnes || fromBits != toBits {
+		return fmt.Errorf("cannot move %s to %s: both must be the same size and address family", from, to)
+	}
+	if from == to {
+		return fmt.Errorf("cannot move %s onto itself", from)
+	}
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+
+	var ipam models.IPAM
+	var results []resultutil.Result
+	err := fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		mappings, err := moveSubtree(ipam.Subnets, fromNet, toNet)
+		if err != nil {
+			return err
+		}
+		for _, m := range mappings {
+			path, _, _ := treeutil.Find(ipam.Subnets, m.To)
+			if path == nil {
+				path = []string{}
+			}
+			results = append(results, resultutil.Result{Action: resultutil.Moved, CIDR: m.To, From: m.From, Parents: path, Renested: []string{}})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, results)
+}
+
+// moveSubtree detaches fromNet from tree, renumbers it and re-inserts it at
+// toNet under the deepest subnet that contains the destination.
+func moveSubtree(tree map[string]models.Subnets, fromNet, toNet *net.IPNet) ([]Mapping, error) {
+	from := fromNet.String()
+	level, ok := treeutil.ContainingMap(tree, from)
+	if !ok {
+		return nil, fmt.Errorf("subnet %q does not exist in IPAM data", from)
+	}
+	node := level[from]
+	delete(level, from)
+
+	remaining, err := treeutil.Nets(tree)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(remaining, subnetutils.CompareNets)
+	toOnes, _ := toNet.Mask.Size()
+	for _, d := range remaining {
+		if subnetutils.CandidateBlocked(toNet, toOnes, []*net.IPNet{d}) {
+			return nil, fmt.Errorf("cannot move %s to %s: %s is already allocated there", from, toNet, d)
+		}
+	}
+
+	_, bits := fromNet.Mask.Size()
+	offset := new(big.Int).Sub(subnetutils.IPToInt(toNet.IP, bits), subnetutils.IPToInt(fromNet.IP, bits))
+
+	mappings := []Mapping{{From: from, To: toNet.String()}}
+	moved, err := renumber(node, offset, bits, &mappings)
+	if err != nil {
+		return nil, err
+	}
+	if err := treeutil.InsertAtDeepest(tree, toNet, moved); err != nil {
+		return nil, err
+	}
+	if path, _, _ := treeutil.Find(tree, toNet.String()); len(path) > 0 {
+		parent := path[len(path)-1]
+		_, node, _ := treeutil.Find(tree, parent)
+		if err := treeutil.CheckNestable(parent, node); err != nil {
+			return nil, fmt.Errorf("cannot move %s to %s: %v", from, toNet, err)
+		}
+	}
+	return mappings, nil
+}
+
+// renumber returns a copy of node whose subnets and addresses are shifted by
+// offset, recording each subnet change in mappings in numeric order.
+func renumber(node models.Subnets, offset *big.Int, bits int, mappings *[]Mapping) (models.Subnets, error) {
+	out := node
+	addresses, err := treeutil.ShiftAddresses(node.Addresses, offset, bits)
+	if err != nil {
+		return models.Subnets{}, err
+	}
+	out.Addresses = addresses
+	out.Subnets = make(map[string]models.Subnets, len(node.Subnets))
+	for _, cidr := range treeutil.SortedCIDRs(node.Subnets) {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return models.Subnets{}, fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		ones, _ := n.Mask.Size()
+		start := new(big.Int).Add(subnetutils.IPToInt(n.IP, bits), offset)
+		moved := subnetutils.NetFromInt(start, ones, bits).String()
+		*mappings = append(*mappings, Mapping{From: cidr, To: moved})
+
+		child, err := renumber(node.Subnets[cidr], offset, bits, mappings)
+		if err != nil {
+			return models.Subnets{}, err
+		}
+		out.Subnets[moved] = child
+	}
+	return out, nil
+}
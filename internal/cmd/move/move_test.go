@@ -0,0 +1,204 @@
+package move
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+)
+
+// copySeed copies the seed file into a temporary directory so each test can
+// modify its own copy.
+func copySeed(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/seed.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading seed: %v", err)
+	}
+	testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("unexpected error writing test file: %v", err)
+	}
+	return testFile
+}
+
+func Test_Move(t *testing.T) {
+	testFile := copySeed(t)
+
+	var out bytes.Buffer
+	if err := Move(&out, testFile, "10.10.0.0/24", "10.2.5.0/24", "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantOut, err := os.ReadFile("testdata/mappings_expected.txt")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if out.String() != string(wantOut) {
+		t.Errorf("got output:\n%s\nwant:\n%s", out.String(), wantOut)
+	}
+
+	want, err := os.ReadFile("testdata/move_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_MoveDryRun(t *testing.T) {
+	testFile := copySeed(t)
+	before, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	var out, diff bytes.Buffer
+	if err := Move(&out, testFile, "10.10.0.0/24", "10.2.5.0/24", "plain", fileutil.UpdateOptions{DryRun: true, DiffOutput: &diff}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(diff.String(), "-            10.10.0.0/24:\n+            10.2.5.0/24:\n") {
+		t.Errorf("diff does not show the move:\n%s", diff.String())
+	}
+
+	want, err := os.ReadFile("testdata/mappings_expected.txt")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if out.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	after, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("dry run changed the file:\n%s", after)
+	}
+}
+
+func Test_MoveErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr string
+	}{
+		{
+			name:    "destination allocated",
+			from:    "10.10.0.0/24",
+			to:      "10.10.1.0/24",
+			wantErr: "cannot move 10.10.0.0/24 to 10.10.1.0/24: 10.10.1.0/24 is already allocated there",
+		},
+		{
+			name:    "destination would swallow a subnet",
+			from:    "10.2.0.0/16",
+			to:      "10.10.0.0/16",
+			wantErr: "cannot move 10.2.0.0/16 to 10.10.0.0/16: 10.10.0.0/20 is already allocated there",
+		},
+		{
+			name:    "different sizes",
+			from:    "10.10.0.0/24",
+			to:      "10.2.0.0/23",
+			wantErr: "cannot move 10.10.0.0/24 to 10.2.0.0/23: both must be the same size and address family",
+		},
+		{
+			name:    "onto itself",
+			from:    "10.10.0.0/24",
+			to:      "10.10.0.0/24",
+			wantErr: "cannot move 10.10.0.0/24 onto itself",
+		},
+		{
+			name:    "not in IPAM",
+			from:    "10.10.2.0/24",
+			to:      "10.2.5.0/24",
+			wantErr: `subnet "10.10.2.0/24" does not exist in IPAM data`,
+		},
+		{
+			name:    "invalid notation",
+			from:    "10.10.0.1/24",
+			to:      "10.2.5.0/24",
+			wantErr: "invalid subnet: 10.10.0.1/24 is not valid CIDR notation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFile := copySeed(t)
+			err := Move(&bytes.Buffer{}, testFile, tt.from, tt.to, "plain", fileutil.UpdateOptions{})
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_MoveShiftsAddresses(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/24:
+        description: old
+        tags: []
+        addresses:
+            10.0.0.10:
+                hostname: web-1
+        subnets: {}
+`
+	testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(testFile, []byte(seed), 0644); err != nil {
+		t.Fatalf("unexpected error writing test file: %v", err)
+	}
+
+	if err := Move(io.Discard, testFile, "10.0.0.0/24", "10.9.1.0/24", "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `description: ""
+subnets:
+    10.9.1.0/24:
+        description: old
+        tags: []
+        addresses:
+            10.9.1.10:
+                hostname: web-1
+        subnets: {}
+`
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_MoveJSON(t *testing.T) {
+	testFile := copySeed(t)
+
+	var out bytes.Buffer
+	if err := Move(&out, testFile, "10.10.0.0/24", "10.2.5.0/24", "json", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/move_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if out.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,384 @@
+package plan
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/addnextavailable"
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+// Compute returns the changes that turn current into desired, in the order
+// they must be applied. Named allocations are resolved against the state left
+// by the other changes, so their CIDRs are fixed by the plan.
+func Compute(current models.IPAM, desired Desired) ([]Change, error) {
+	err := treeutil.Walk(desired.Subnets, func(path []string, cidr string, node models.Subnets) error {
+		if err := subnetutils.CheckValidSubnet(cidr); err != nil {
+			return fmt.Errorf("invalid subnet in desired state: %v", err)
+		}
+		if _, err := addressutil.ParseRules(node.Reserve); err != nil {
+			return fmt.Errorf("%s in desired state: %v", cidr, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cur := treeutil.Flatten(current.Subnets)
+	want := treeutil.Flatten(desired.Subnets)
+
+	allocations := map[string]addnextavailable.Request{}
+	for _, r := range desired.Allocations {
+		if r.Name == "" {
+			return nil, fmt.Errorf("allocation under %s has no name", r.Parent)
+		}
+		if _, ok := allocations[r.Name]; ok {
+			return nil, fmt.Errorf("allocation %q is requested more than once", r.Name)
+		}
+		if _, err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("allocation %q: %v", r.Name, err)
+		}
+		allocations[r.Name] = r
+	}
+
+	var changes []Change
+	if current.Description != desired.Description {
+		changes = append(changes, Change{
+			Action: Describe,
+			Old:    &Attrs{Description: current.Description},
+			New:    &Attrs{Description: desired.Description},
+		})
+	}
+
+	// Sort the current subnets into kept, updated and deleted ones, and note
+	// which named allocations are already satisfied.
+	deleted := map[string]bool{}
+	satisfied := map[string]bool{}
+	for _, cidr := range treeutil.SortedCIDRs(cur) {
+		node := cur[cidr]
+		target, explicit := want[cidr]
+		if !explicit {
+			r, named := allocations[node.Name]
+			if !named || satisfied[node.Name] || !fits(cidr, r) {
+				deleted[cidr] = true
+				continue
+			}
+			satisfied[node.Name] = true
+			target = models.Subnets{Name: r.Name, Description: r.Description, Tags: r.Tags, Reserve: node.Reserve}
+		}
+		if before, after := attrsOf(node), attrsOf(target); !sameAttrs(before, after) {
+			changes = append(changes, Change{Action: Update, CIDR: cidr, Old: before, New: after})
+		}
+	}
+	added := map[string]bool{}
+	for cidr, node := range want {
+		if _, ok := allocations[node.Name]; ok {
+			satisfied[node.Name] = true // listed explicitly, so nothing to allocate
+		}
+		if _, ok := cur[cidr]; !ok {
+			added[cidr] = true
+		}
+	}
+
+	moves, err := findMoves(current.Subnets, cur, want, deleted, added)
+	if err != nil {
+		return nil, err
+	}
+
+	var explicit []Change
+	for _, cidr := range treeutil.SortedCIDRs(cur) {
+		if deleted[cidr] {
+			explicit = append(explicit, Change{Action: Delete, CIDR: cidr, Old: attrsOf(cur[cidr])})
+		}
+	}
+	explicit = append(explicit, moves...)
+	explicit = append(explicit, changes...)
+	for _, cidr := range treeutil.SortedCIDRs(want) {
+		if added[cidr] {
+			explicit = append(explicit, Change{Action: Add, CIDR: cidr, New: attrsOf(want[cidr])})
+		}
+	}
+
+	// Resolve the outstanding allocations on top of everything else, in
+	// the order they were requested.
+	state := models.IPAM{Description: current.Description, Subnets: current.Subnets}
+	if err := ApplyChanges(&state, explicit); err != nil {
+		return nil, err
+	}
+	for _, r := range desired.Allocations {
+		if satisfied[r.Name] {
+			continue
+		}
+		chosen, err := addnextavailable.Allocate(state.Subnets, r, nil)
+		if err != nil {
+			return nil, fmt.Errorf("allocation %q: %v", r.Name, err)
+		}
+		explicit = append(explicit, Change{
+			Action: Allocate,
+			CIDR:   chosen.CIDR,
+			Parent: r.Parent,
+			New:    &Attrs{Name: r.Name, Description: r.Description, Tags: tagsOrEmpty(r.Tags)},
+		})
+	}
+	if explicit == nil {
+		explicit = []Change{}
+	}
+	return explicit, nil
+}
+
+// findMoves pairs each deleted subtree with an added subtree that holds the
+// same subnets and metadata shifted by a fixed offset, removing both from
+// deleted and added.
+func findMoves(curTree map[string]models.Subnets, cur, want map[string]models.Subnets, deleted, added map[string]bool) ([]Change, error) {
+	wantBuilt, err := treeutil.Build(want)
+	if err != nil {
+		return nil, err
+	}
+	fromRoots := subtreeRoots(curTree, deleted)
+	toRoots := subtreeRoots(wantBuilt, added)
+
+	var moves []Change
+	for _, from := range fromRoots {
+		for i, to := range toRoots {
+			if to.cidr == "" || !sameShape(from, to, cur, want, deleted, added) {
+				continue
+			}
+			for _, cidr := range from.members {
+				delete(deleted, cidr)
+			}
+			for _, cidr := range to.members {
+				delete(added, cidr)
+			}
+			moves = append(moves, Change{Action: Move, CIDR: from.cidr, To: to.cidr})
+			toRoots[i].cidr = "" // matched
+			break
+		}
+	}
+	return moves, nil
+}
+
+// subtree is the top of a run of marked subnets and every subnet under it.
+type subtree struct {
+	cidr    string
+	members []string
+}
+
+// subtreeRoots returns the marked subnets of tree whose parent is not
+// marked, each with all of its descendants.
+func subtreeRoots(tree map[string]models.Subnets, marked map[string]bool) []subtree {
+	var roots []subtree
+	_ = treeutil.Walk(tree, func(path []string, cidr string, node models.Subnets) error {
+		if !marked[cidr] {
+			return nil
+		}
+		members := []string{cidr}
+		_ = treeutil.Walk(node.Subnets, func(path []string, cidr string, node models.Subnets) error {
+			members = append(members, cidr)
+			return nil
+		})
+		roots = append(roots, subtree{cidr: cidr, members: members})
+		return treeutil.SkipChildren
+	})
+	return roots
+}
+
+// sameShape reports whether to is from shifted to a new address with every
+// subnet and its metadata intact. Every member of from must be marked for
+// deletion, or part of it is staying where it is, and every member of to
+// must be new.
+func sameShape(from, to subtree, cur, want map[string]models.Subnets, deleted, added map[string]bool) bool {
+	if len(from.members) != len(to.members) {
+		return false
+	}
+	for i := range from.members {
+		if !deleted[from.members[i]] || !added[to.members[i]] {
+			return false
+		}
+	}
+	_, fromNet, _ := net.ParseCIDR(from.cidr)
+	_, toNet, _ := net.ParseCIDR(to.cidr)
+	fromOnes, bits := fromNet.Mask.Size()
+	if toOnes, toBits := toNet.Mask.Size(); toOnes != fromOnes || toBits != bits {
+		return false
+	}
+	offset := new(big.Int).Sub(subnetutils.IPToInt(toNet.IP, bits), subnetutils.IPToInt(fromNet.IP, bits))
+
+	targets := map[string]bool{}
+	for _, cidr := range to.members {
+		targets[cidr] = true
+	}
+	for _, cidr := range from.members {
+		shifted, err := shift(cidr, offset)
+		if err != nil || !targets[shifted] || !sameAttrs(attrsOf(cur[cidr]), attrsOf(want[shifted])) {
+			return false
+		}
+	}
+	return true
+}
+
+func shift(cidr string, offset *big.Int) (string, error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+	}
+	ones, bits := n.Mask.Size()
+	start := new(big.Int).Add(subnetutils.IPToInt(n.IP, bits), offset)
+	return subnetutils.NetFromInt(start, ones, bits).String(), nil
+}
+
+// fits reports whether cidr satisfies allocation request r.
+func fits(cidr string, r addnextavailable.Request) bool {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := n.Mask.Size()
+	inside, err := subnetutils.IsSubnetOf(r.Parent, cidr)
+	return err == nil && inside && ones == r.PrefixLength
+}
+
+// ApplyChanges applies changes, in order, to ipam. Each change is checked
+// against the state it applies to, so a plan edited by hand cannot leave
+// the tree inconsistent.
+func ApplyChanges(ipam *models.IPAM, changes []Change) error {
+	flat := treeutil.Flatten(ipam.Subnets)
+	for _, c := range changes {
+		if err := applyChange(ipam, flat, c); err != nil {
+			return fmt.Errorf("cannot %s %s: %v", c.Action, c.CIDR, err)
+		}
+	}
+	subnets, err := treeutil.Build(flat)
+	if err != nil {
+		return err
+	}
+	if err := treeutil.CheckLeafAddresses(subnets); err != nil {
+		return err
+	}
+	ipam.Subnets = subnets
+	return nil
+}
+
+func applyChange(ipam *models.IPAM, flat map[string]models.Subnets, c Change) error {
+	_, exists := flat[c.CIDR]
+	switch c.Action {
+	case Describe:
+		if c.New == nil {
+			return fmt.Errorf("no new description given")
+		}
+		ipam.Description = c.New.Description
+	case Delete:
+		if !exists {
+			return fmt.Errorf("subnet does not exist")
+		}
+		// Addresses are not managed by plan, so a plan may not drop them.
+		if addrs := flat[c.CIDR].Addresses; len(addrs) > 0 {
+			return fmt.Errorf("address(es) are assigned in it (%s); release them before deleting it", strings.Join(treeutil.SortedAddresses(addrs), ", "))
+		}
+		delete(flat, c.CIDR)
+	case Update:
+		if !exists {
+			return fmt.Errorf("subnet does not exist")
+		}
+		if c.New == nil {
+			return fmt.Errorf("no new attributes given")
+		}
+		node := nodeOf(c.New)
+		node.Addresses = flat[c.CIDR].Addresses
+		flat[c.CIDR] = node
+	case Add, Allocate:
+		if exists {
+			return fmt.Errorf("subnet already exists")
+		}
+		if err := subnetutils.CheckValidSubnet(c.CIDR); err != nil {
+			return err
+		}
+		if c.New == nil {
+			c.New = &Attrs{}
+		}
+		flat[c.CIDR] = nodeOf(c.New)
+	case Move:
+		if !exists {
+			return fmt.Errorf("subnet does not exist")
+		}
+		return moveFlat(flat, c.CIDR, c.To)
+	default:
+		return fmt.Errorf("unknown action %q", c.Action)
+	}
+	return nil
+}
+
+// moveFlat renames from and every subnet inside it so they sit at the same
+// offsets inside to, taking their assigned addresses with them.
+func moveFlat(flat map[string]models.Subnets, from, to string) error {
+	if err := subnetutils.CheckValidSubnet(to); err != nil {
+		return err
+	}
+	_, fromNet, _ := net.ParseCIDR(from)
+	_, toNet, _ := net.ParseCIDR(to)
+	fromOnes, bits := fromNet.Mask.Size()
+	if toOnes, toBits := toNet.Mask.Size(); toOnes != fromOnes || toBits != bits {
+		return fmt.Errorf("%s is not the same size as %s", to, from)
+	}
+	offset := new(big.Int).Sub(subnetutils.IPToInt(toNet.IP, bits), subnetutils.IPToInt(fromNet.IP, bits))
+
+	var members []string
+	for cidr := range flat {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		if subnetutils.CandidateBlocked(toNet, fromOnes, []*net.IPNet{n}) {
+			return fmt.Errorf("%s is already allocated at the destination", cidr)
+		}
+		if subnetutils.CandidateBlocked(fromNet, fromOnes, []*net.IPNet{n}) {
+			members = append(members, cidr)
+		}
+	}
+	moved := map[string]models.Subnets{}
+	for _, cidr := range members {
+		shifted, err := shift(cidr, offset)
+		if err != nil {
+			return err
+		}
+		node := flat[cidr]
+		if node.Addresses, err = treeutil.ShiftAddresses(node.Addresses, offset, bits); err != nil {
+			return err
+		}
+		moved[shifted] = node
+		delete(flat, cidr)
+	}
+	for cidr, node := range moved {
+		flat[cidr] = node
+	}
+	return nil
+}
+
+func attrsOf(node models.Subnets) *Attrs {
+	return &Attrs{Name: node.Name, Description: node.Description, Tags: tagsOrEmpty(node.Tags), Reserve: node.Reserve}
+}
+
+func nodeOf(a *Attrs) models.Subnets {
+	return models.Subnets{Name: a.Name, Description: a.Description, Tags: tagsOrEmpty(a.Tags), Reserve: a.Reserve, Subnets: map[string]models.Subnets{}}
+}
+
+func sameAttrs(a, b *Attrs) bool {
+	return a.Name == b.Name && a.Description == b.Description && equalTags(a.Tags, b.Tags) && slices.Equal(a.Reserve, b.Reserve)
+}
+
+func equalTags(a, b []string) bool {
+	return slices.Equal(tagsOrEmpty(a), tagsOrEmpty(b))
+}
+
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
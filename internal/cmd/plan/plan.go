@@ -0,0 +1,259 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/addnextavailable"
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+)
+
+var inputFile, desiredFile, output, outFile string
+
+var PlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes needed to reach a desired state",
+	Long: `Show the changes needed to turn an IPAM file into a desired state.
+
+The desired-state file has the same shape as an IPAM file, plus a list of
+named allocations resolved with the next-available logic:
+
+  subnets:
+      10.0.0.0/16:
+          description: us-east
+          tags: []
+          subnets: {}
+  allocations:
+      - name: vpc-a-web
+        parent: 10.0.0.0/16
+        prefix-length: 24
+        description: web
+
+Subnets missing from the desired state are deleted, new ones are added and
+differing descriptions, tags, names or reserve rules are updated. A subtree that only
+changed address is shown as a move. A named allocation that already exists
+under its parent with the requested size is kept where it is. Addresses
+assigned with assign-ip are not managed by plan and stay with their subnet;
+plan refuses to delete a subnet that still has addresses assigned in it.
+
+Use --out to save the plan for 'apply', which refuses to run if the IPAM
+file has changed since.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Plan(cmd.OutOrStdout(), inputFile, desiredFile, output, outFile)
+	},
+}
+
+func init() {
+	PlanCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	PlanCmd.Flags().StringVar(&desiredFile, "desired", "", "desired-state file")
+	_ = PlanCmd.MarkFlagRequired("file")
+	_ = PlanCmd.MarkFlagRequired("desired")
+	PlanCmd.Flags().StringVarP(&output, "output", "o", "plain", "output format: plain or json")
+	PlanCmd.Flags().StringVar(&outFile, "out", "", "save the plan to this file for 'apply'")
+}
+
+// Desired is the content of a desired-state file.
+type Desired struct {
+	Description string
+	Subnets     map[string]models.Subnets
+	Allocations []addnextavailable.Request
+}
+
+// Kinds of change, in the order they are applied.
+const (
+	Delete   = "delete"
+	Move     = "move"
+	Update   = "update"
+	Describe = "describe"
+	Add      = "add"
+	Allocate = "allocate"
+)
+
+// Attrs are the metadata of a subnet, or of the file for Describe.
+type Attrs struct {
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Reserve     []string `json:"reserve,omitempty"`
+}
+
+// Change is one step of a plan. CIDR is empty for Describe, To is set for
+// Move and Parent for Allocate. Old holds the metadata being replaced or
+// removed and New the metadata being written.
+type Change struct {
+	Action string `json:"action"`
+	CIDR   string `json:"cidr,omitempty"`
+	To     string `json:"to,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	Old    *Attrs `json:"old,omitempty"`
+	New    *Attrs `json:"new,omitempty"`
+}
+
+// File is a saved plan: the changes and the hash of the IPAM file they were
+// computed against.
+type File struct {
+	Hash    string   `json:"hash"`
+	Changes []Change `json:"changes"`
+}
+
+// Plan computes the changes that turn inputFile into the state described by
+// desiredFile and prints them. If outFile is set the plan is also saved there.
+func Plan(w io.Writer, inputFile, desiredFile, output, outFile string) error {
+	if output != "plain" && output != "json" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain or json", output)
+	}
+
+	data, err := fileutil.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	var ipam models.IPAM
+	if err := yaml.Unmarshal(data, &ipam); err != nil {
+		return fmt.Errorf("error unmarshaling IPAM: %v", err)
+	}
+
+	desired, err := ReadDesired(desiredFile)
+	if err != nil {
+		return err
+	}
+
+	changes, err := Compute(ipam, desired)
+	if err != nil {
+		return err
+	}
+	planFile := File{Hash: fileutil.Hash(data), Changes: changes}
+
+	if outFile != "" {
+		saved, err := json.MarshalIndent(planFile, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling plan: %v", err)
+		}
+		if err := os.WriteFile(outFile, append(saved, '\n'), 0644); err != nil {
+			return fmt.Errorf("error writing plan: %v", err)
+		}
+	}
+
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(planFile)
+	}
+	return Write(w, changes)
+}
+
+// ReadDesired reads a desired-state file, rejecting unknown keys so that a
+// misspelt one is not silently ignored.
+func ReadDesired(path string) (Desired, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Desired{}, fmt.Errorf("error reading desired state: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var desired Desired
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&desired); err != nil && err != io.EOF {
+		return Desired{}, fmt.Errorf("error parsing desired state: %v", err)
+	}
+	return desired, nil
+}
+
+// ReadFile reads a plan saved by Plan.
+func ReadFile(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, fmt.Errorf("error reading plan: %v", err)
+	}
+	var planFile File
+	if err := json.Unmarshal(data, &planFile); err != nil {
+		return File{}, fmt.Errorf("error parsing plan: %v", err)
+	}
+	return planFile, nil
+}
+
+// Write prints changes one per line, followed by a summary.
+func Write(w io.Writer, changes []Change) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "No changes. The IPAM file matches the desired state.")
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, c := range changes {
+		var line string
+		switch c.Action {
+		case Delete:
+			line = "- " + c.CIDR + attrs(c.Old)
+			counts[Delete]++
+		case Move:
+			line = "> " + c.CIDR + " -> " + c.To
+			counts[Move]++
+		case Update:
+			line = "~ " + c.CIDR + diff(c.Old, c.New)
+			counts[Update]++
+		case Describe:
+			line = fmt.Sprintf("~ (file) description=%q -> %q", c.Old.Description, c.New.Description)
+			counts[Update]++
+		case Add:
+			line = "+ " + c.CIDR + attrs(c.New)
+			counts[Add]++
+		case Allocate:
+			line = "+ " + c.CIDR + attrs(c.New) + " (next available in " + c.Parent + ")"
+			counts[Add]++
+		default:
+			return fmt.Errorf("unknown action %q", c.Action)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\nPlan: %d to add, %d to update, %d to move, %d to delete.\n", counts[Add], counts[Update], counts[Move], counts[Delete])
+	return err
+}
+
+func attrs(a *Attrs) string {
+	if a == nil {
+		return ""
+	}
+	var sb strings.Builder
+	if a.Name != "" {
+		fmt.Fprintf(&sb, " name=%s", a.Name)
+	}
+	if a.Description != "" {
+		fmt.Fprintf(&sb, " description=%q", a.Description)
+	}
+	if len(a.Tags) > 0 {
+		fmt.Fprintf(&sb, " tags=[%s]", strings.Join(a.Tags, ", "))
+	}
+	if len(a.Reserve) > 0 {
+		fmt.Fprintf(&sb, " reserve=[%s]", strings.Join(a.Reserve, ", "))
+	}
+	return sb.String()
+}
+
+func diff(before, after *Attrs) string {
+	var sb strings.Builder
+	if before.Name != after.Name {
+		fmt.Fprintf(&sb, " name=%q -> %q", before.Name, after.Name)
+	}
+	if before.Description != after.Description {
+		fmt.Fprintf(&sb, " description=%q -> %q", before.Description, after.Description)
+	}
+	if !equalTags(before.Tags, after.Tags) {
+		fmt.Fprintf(&sb, " tags=[%s] -> [%s]", strings.Join(before.Tags, ", "), strings.Join(after.Tags, ", "))
+	}
+	if !slices.Equal(before.Reserve, after.Reserve) {
+		fmt.Fprintf(&sb, " reserve=[%s] -> [%s]", strings.Join(before.Reserve, ", "), strings.Join(after.Reserve, ", "))
+	}
+	return sb.String()
+}
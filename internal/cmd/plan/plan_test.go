@@ -0,0 +1,130 @@
+package plan
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/addnextavailable"
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+)
+
+func Test_Plan(t *testing.T) {
+	var out bytes.Buffer
+	if err := Plan(&out, "testdata/seed.yaml", "testdata/desired.yaml", "plain", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/plan_expected.txt")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	if out.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func Test_PlanNoChanges(t *testing.T) {
+	var out bytes.Buffer
+	if err := Plan(&out, "testdata/seed.yaml", "testdata/seed.yaml", "plain", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "No changes. The IPAM file matches the desired state.\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func Test_ComputeKeepsSatisfiedAllocation(t *testing.T) {
+	current := models.IPAM{Subnets: map[string]models.Subnets{
+		"10.0.0.0/24": {Tags: []string{}, Subnets: map[string]models.Subnets{
+			"10.0.0.128/26": {Name: "web", Description: "web", Tags: []string{}, Subnets: map[string]models.Subnets{}},
+		}},
+	}}
+	desired := Desired{
+		Subnets: map[string]models.Subnets{
+			"10.0.0.0/24": {Tags: []string{}, Subnets: map[string]models.Subnets{}},
+		},
+		Allocations: []addnextavailable.Request{
+			{Name: "web", Parent: "10.0.0.0/24", PrefixLength: 26, Description: "web"},
+		},
+	}
+
+	changes, err := Compute(current, desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func Test_ComputeErrors(t *testing.T) {
+	parent := map[string]models.Subnets{"10.0.0.0/24": {}}
+	tests := []struct {
+		name    string
+		desired Desired
+		wantErr string
+	}{
+		{
+			name:    "invalid subnet",
+			desired: Desired{Subnets: map[string]models.Subnets{"10.0.0.1/24": {}}},
+			wantErr: "invalid subnet in desired state",
+		},
+		{
+			name:    "unnamed allocation",
+			desired: Desired{Subnets: parent, Allocations: []addnextavailable.Request{{Parent: "10.0.0.0/24", PrefixLength: 26}}},
+			wantErr: "has no name",
+		},
+		{
+			name: "duplicate allocation",
+			desired: Desired{Subnets: parent, Allocations: []addnextavailable.Request{
+				{Name: "a", Parent: "10.0.0.0/24", PrefixLength: 26},
+				{Name: "a", Parent: "10.0.0.0/24", PrefixLength: 27},
+			}},
+			wantErr: `allocation "a" is requested more than once`,
+		},
+		{
+			name: "no space",
+			desired: Desired{Subnets: parent, Allocations: []addnextavailable.Request{
+				{Name: "a", Parent: "10.0.0.0/24", PrefixLength: 25},
+				{Name: "b", Parent: "10.0.0.0/24", PrefixLength: 25},
+				{Name: "c", Parent: "10.0.0.0/24", PrefixLength: 25},
+			}},
+			wantErr: `allocation "c": no available /25 subnet in 10.0.0.0/24`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compute(models.IPAM{}, tt.desired)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_ComputeRefusesDeletingAddresses(t *testing.T) {
+	current := models.IPAM{Subnets: map[string]models.Subnets{
+		"10.0.0.0/24": {Tags: []string{}, Subnets: map[string]models.Subnets{
+			"10.0.0.0/26": {
+				Tags:      []string{},
+				Addresses: map[string]models.Address{"10.0.0.4": {Hostname: "h1"}},
+				Subnets:   map[string]models.Subnets{},
+			},
+		}},
+	}}
+	desired := Desired{Subnets: map[string]models.Subnets{
+		"10.0.0.0/24": {Tags: []string{}, Subnets: map[string]models.Subnets{}},
+	}}
+
+	_, err := Compute(current, desired)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "cannot delete 10.0.0.0/26: address(es) are assigned in it (10.0.0.4); release them before deleting it"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,69 @@
+package releaseip
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+)
+
+var subnet, ip, inputFile, output string
+var opts fileutil.UpdateOptions
+
+var ReleaseIPCmd = &cobra.Command{
+	Use:          "release-ip",
+	Short:        "Release a host address assigned in a subnet",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ReleaseIP(cmd.OutOrStdout(), inputFile, subnet, ip, output, opts)
+	},
+}
+
+func init() {
+	ReleaseIPCmd.Flags().StringVarP(&subnet, "subnet", "s", "", "subnet holding the address")
+	ReleaseIPCmd.Flags().StringVarP(&ip, "ip", "i", "", "address to release")
+	ReleaseIPCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = ReleaseIPCmd.MarkFlagRequired("subnet")
+	_ = ReleaseIPCmd.MarkFlagRequired("ip")
+	_ = ReleaseIPCmd.MarkFlagRequired("file")
+	resultutil.AddOutputFlag(ReleaseIPCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(ReleaseIPCmd, &opts)
+}
+
+// ReleaseIP removes the record of ip from subnet.
+func ReleaseIP(w io.Writer, inputFile, subnet, ip, output string, opts fileutil.UpdateOptions) error {
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+
+	var ipam models.IPAM
+	var result resultutil.Result
+	err := fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		level, n, err := addressutil.Locate(ipam.Subnets, subnet)
+		if err != nil {
+			return err
+		}
+		if _, err := addressutil.ParseIP(ip, n); err != nil {
+			return err
+		}
+		node := level[subnet]
+		if _, ok := node.Addresses[ip]; !ok {
+			return fmt.Errorf("%s is not assigned in %s", ip, subnet)
+		}
+		delete(node.Addresses, ip)
+		level[subnet] = node
+
+		result = resultutil.At(ipam.Subnets, resultutil.Released, subnet)
+		result.IP = ip
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, []resultutil.Result{result})
+}
@@ -0,0 +1,71 @@
+package releaseip
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+)
+
+const seed = `description: ""
+subnets:
+    10.10.0.0/24:
+        description: test subnet
+        tags: []
+        addresses:
+            10.10.0.1:
+                hostname: gw
+            10.10.0.2:
+                hostname: web-1
+        subnets: {}
+`
+
+func writeSeed(t *testing.T) string {
+	t.Helper()
+	testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(testFile, []byte(seed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+	return testFile
+}
+
+func Test_ReleaseIP(t *testing.T) {
+	testFile := writeSeed(t)
+
+	var out bytes.Buffer
+	if err := ReleaseIP(&out, testFile, "10.10.0.0/24", "10.10.0.2", "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "10.10.0.2\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	want, err := os.ReadFile("testdata/release_ip_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_ReleaseIPNotAssigned(t *testing.T) {
+	testFile := writeSeed(t)
+
+	err := ReleaseIP(io.Discard, testFile, "10.10.0.0/24", "10.10.0.3", "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "10.10.0.3 is not assigned in 10.10.0.0/24"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
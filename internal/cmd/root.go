@@ -1,22 +1,55 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/kyle-burnett/simple-ipam/internal/cmd/add"
 	"github.com/kyle-burnett/simple-ipam/internal/cmd/addnextavailable"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/apply"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/assignip"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/assignnextip"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/check"
 	"github.com/kyle-burnett/simple-ipam/internal/cmd/delete"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/find"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/free"
 	"github.com/kyle-burnett/simple-ipam/internal/cmd/initialize"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/list"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/lookup"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/move"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/plan"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/releaseip"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/split"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/summarize"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/update"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/utilization"
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/validate"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
 )
 
+var printHash bool
+
 var rootCmd = &cobra.Command{
 	Use:   "simple-ipam",
 	Short: "Simple CLI IPAM Tool",
 	CompletionOptions: cobra.CompletionOptions{
 		DisableDefaultCmd: true,
 	},
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if printHash {
+			fileutil.OnRead = func(path, hash string) {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "%s  %s\n", hash, path)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&printHash, "print-hash", false, "print the content hash of the ipam file read to stderr, for use with --if-match")
 }
 
 var genDocsCmd = &cobra.Command{
@@ -34,11 +67,31 @@ func generateMarkdownDocs(cmd *cobra.Command) error {
 func Execute() {
 	rootCmd.AddCommand(add.AddCmd)
 	rootCmd.AddCommand(addnextavailable.AddNextAvailableCmd)
+	rootCmd.AddCommand(apply.ApplyCmd)
+	rootCmd.AddCommand(assignip.AssignIPCmd)
+	rootCmd.AddCommand(assignnextip.AssignNextIPCmd)
+	rootCmd.AddCommand(check.CheckCmd)
 	rootCmd.AddCommand(delete.DeleteCmd)
+	rootCmd.AddCommand(find.FindCmd)
+	rootCmd.AddCommand(free.FreeCmd)
 	rootCmd.AddCommand(initialize.InitCmd)
+	rootCmd.AddCommand(list.ListCmd)
+	rootCmd.AddCommand(lookup.LookupCmd)
+	rootCmd.AddCommand(move.MoveCmd)
+	rootCmd.AddCommand(plan.PlanCmd)
+	rootCmd.AddCommand(releaseip.ReleaseIPCmd)
+	rootCmd.AddCommand(split.SplitCmd)
+	rootCmd.AddCommand(summarize.SummarizeCmd)
+	rootCmd.AddCommand(update.UpdateCmd)
+	rootCmd.AddCommand(utilization.UtilizationCmd)
+	rootCmd.AddCommand(validate.ValidateCmd)
 	rootCmd.AddCommand(genDocsCmd)
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		var exitErr *exitutil.Error
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
+		os.Exit(exitutil.General)
 	}
 }
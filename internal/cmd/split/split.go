@@ -0,0 +1,155 @@
+package split
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+// maxBlocks caps how many subnets one split may create.
+const maxBlocks = 1 << 16
+
+var subnet, description, inputFile, output string
+var into, count int
+var tags []string
+var opts fileutil.UpdateOptions
+
+var SplitCmd = &cobra.Command{
+	Use:   "split",
+	Short: "Carve a subnet into equal-sized child subnets",
+	Long: `Carve a subnet into equal-sized child subnets in one step.
+
+Give the size of the children with --into or how many to make with --count.
+The description may contain {index}, replaced by the child's position
+starting at 0, and {cidr}, replaced by the child's CIDR. Nothing is written
+if any of the children is already taken.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Split(cmd.OutOrStdout(), inputFile, subnet, into, count, description, tags, output, opts)
+	},
+}
+
+func init() {
+	SplitCmd.Flags().StringVarP(&subnet, "subnet", "s", "", "subnet to split")
+	SplitCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = SplitCmd.MarkFlagRequired("subnet")
+	_ = SplitCmd.MarkFlagRequired("file")
+	SplitCmd.Flags().IntVar(&into, "into", 0, "prefix length of the child subnets")
+	SplitCmd.Flags().IntVar(&count, "count", 0, "number of child subnets; must be a power of two")
+	SplitCmd.MarkFlagsOneRequired("into", "count")
+	SplitCmd.MarkFlagsMutuallyExclusive("into", "count")
+	SplitCmd.Flags().StringVarP(&description, "description", "d", "", "description template for the child subnets")
+	SplitCmd.Flags().StringSliceVarP(&tags, "tags", "t", []string{}, "Tags to add to every child subnet")
+	resultutil.AddOutputFlag(SplitCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(SplitCmd, &opts)
+}
+
+// Split creates every /into child of subnet, or count equal children if into
+// is 0. subnet must already exist in inputFile.
+func Split(w io.Writer, inputFile, subnet string, into, count int, description string, tags []string, output string, opts fileutil.UpdateOptions) error {
+	if err := subnetutils.CheckValidSubnet(subnet); err != nil {
+		return fmt.Errorf("invalid subnet: %v", err)
+	}
+	_, parentNet, _ := net.ParseCIDR(subnet)
+	ones, addrBits := parentNet.Mask.Size()
+
+	if into == 0 {
+		if count < 2 || bits.OnesCount(uint(count)) != 1 {
+			return fmt.Errorf("count must be a power of two greater than 1, got %d", count)
+		}
+		into = ones + bits.TrailingZeros(uint(count))
+	}
+	if into <= ones || into > addrBits {
+		return fmt.Errorf("desired prefix /%d must be longer than %s and <= %d", into, subnet, addrBits)
+	}
+	if into-ones > bits.TrailingZeros(maxBlocks) {
+		return fmt.Errorf("splitting %s into /%d would create more than %d subnets", subnet, into, maxBlocks)
+	}
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+
+	var ipam models.IPAM
+	var results []resultutil.Result
+	err := fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		level, ok := treeutil.ContainingMap(ipam.Subnets, subnet)
+		if !ok {
+			return fmt.Errorf("subnet %q does not exist in IPAM data", subnet)
+		}
+		node := level[subnet]
+		if err := treeutil.CheckNestable(subnet, node); err != nil {
+			return fmt.Errorf("cannot split %s: %v", subnet, err)
+		}
+		if node.Subnets == nil {
+			node.Subnets = map[string]models.Subnets{}
+		}
+
+		if err := checkFree(node.Subnets, into, addrBits); err != nil {
+			return fmt.Errorf("cannot split %s into /%d: %v", subnet, into, err)
+		}
+
+		blocks := 1 << (into - ones)
+		start := subnetutils.IPToInt(parentNet.IP, addrBits)
+		size := subnetutils.BlockSize(into, addrBits)
+		added := make([]string, 0, blocks)
+		for i := range blocks {
+			block := subnetutils.NetFromInt(start, into, addrBits)
+			added = append(added, block.String())
+			entry := models.Subnets{
+				Description: expand(description, i, block),
+				Tags:        slices.Clone(tags),
+				Subnets:     map[string]models.Subnets{},
+			}
+			if err := treeutil.InsertAtDeepest(node.Subnets, block, entry); err != nil {
+				return err
+			}
+			start.Add(start, size)
+		}
+		level[subnet] = node
+		for _, cidr := range added {
+			results = append(results, resultutil.At(ipam.Subnets, resultutil.Added, cidr))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, results)
+}
+
+// checkFree returns an error naming the first existing subnet that blocks
+// one of the /into children.
+func checkFree(children map[string]models.Subnets, into, addrBits int) error {
+	descendants, err := treeutil.Nets(children)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(descendants, subnetutils.CompareNets)
+
+	mask := net.CIDRMask(into, addrBits)
+	for _, d := range descendants {
+		block := &net.IPNet{IP: d.IP.Mask(mask), Mask: mask}
+		if subnetutils.CandidateBlocked(block, into, []*net.IPNet{d}) {
+			return fmt.Errorf("%s is already taken by %s", block, d)
+		}
+	}
+	return nil
+}
+
+// expand fills in the {index} and {cidr} placeholders of a description
+// template.
+func expand(template string, index int, block *net.IPNet) string {
+	return strings.NewReplacer("{index}", strconv.Itoa(index), "{cidr}", block.String()).Replace(template)
+}
@@ -0,0 +1,143 @@
+package split
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
+)
+
+func Test_Split(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testSplit.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	if err = Split(io.Discard, testFile, "10.10.0.0/24", 0, 4, "app-{index} {cidr}", []string{"app"}, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/split_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_SplitErrors(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testSplitErrors.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	before, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		subnet  string
+		into    int
+		count   int
+		wantErr string
+	}{
+		{
+			name:    "block already taken",
+			subnet:  "10.10.0.0/20",
+			into:    22,
+			wantErr: "cannot split 10.10.0.0/20 into /22: 10.10.0.0/22 is already taken by 10.10.0.0/24",
+		},
+		{
+			name:    "count not a power of two",
+			subnet:  "10.10.0.0/24",
+			count:   3,
+			wantErr: "count must be a power of two greater than 1, got 3",
+		},
+		{
+			name:    "prefix not longer",
+			subnet:  "10.10.0.0/24",
+			into:    24,
+			wantErr: "desired prefix /24 must be longer than 10.10.0.0/24 and <= 32",
+		},
+		{
+			name:    "too many blocks",
+			subnet:  "10.0.0.0/8",
+			into:    32,
+			wantErr: "splitting 10.0.0.0/8 into /32 would create more than 65536 subnets",
+		},
+		{
+			name:    "subnet not in IPAM",
+			subnet:  "10.20.0.0/24",
+			into:    26,
+			wantErr: `subnet "10.20.0.0/24" does not exist in IPAM data`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Split(io.Discard, testFile, tt.subnet, tt.into, tt.count, "", nil, "plain", fileutil.UpdateOptions{})
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+
+	after, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("failed splits changed the file:\n%s", after)
+	}
+}
+
+func Test_SplitAssignedAddresses(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/24:
+        description: ""
+        tags: []
+        addresses:
+            10.0.0.5:
+                hostname: web-1
+        subnets: {}
+`
+	testFile := "testSplitAddresses.yaml"
+	if err := os.WriteFile(testFile, []byte(seed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	wantErr := "cannot split 10.0.0.0/24: 10.0.0.0/24 has address(es) assigned in it (10.0.0.5); release them before nesting subnets under it"
+	err := Split(io.Discard, testFile, "10.0.0.0/24", 25, 0, "", nil, "plain", fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if err.Error() != wantErr {
+		t.Errorf("got error %q, want %q", err.Error(), wantErr)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(got) != seed {
+		t.Errorf("file changed:\n%s", got)
+	}
+}
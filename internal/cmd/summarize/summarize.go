@@ -0,0 +1,120 @@
+package summarize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/cmd/find"
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var inputFile, under, output, listName string
+var tags []string
+
+var SummarizeCmd = &cobra.Command{
+	Use:     "summarize",
+	Aliases: []string{"aggregate"},
+	Short:   "Print the fewest prefixes covering a set of subnets",
+	Long: `Print the fewest prefixes covering a set of subnets.
+
+The subnets are chosen with --tag and --under; with neither, every subnet in
+the file is used. Subnets inside another selected subnet are dropped and
+adjacent ones are merged into their common supernet, so the result covers
+exactly the selected address space. Use -o prefix-list to get a snippet for
+a router configuration.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Summarize(cmd.OutOrStdout(), inputFile, under, tags, output, listName)
+	},
+}
+
+func init() {
+	SummarizeCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = SummarizeCmd.MarkFlagRequired("file")
+	SummarizeCmd.Flags().StringArrayVarP(&tags, "tag", "t", []string{}, "tag the subnet must have; prefix with '!' for a tag it must not have (repeatable)")
+	SummarizeCmd.Flags().StringVarP(&under, "under", "u", "", "only use the subnets under this subnet")
+	SummarizeCmd.Flags().StringVarP(&output, "output", "o", "plain", "output format: plain, json or prefix-list")
+	SummarizeCmd.Flags().StringVar(&listName, "prefix-list-name", "ADVERTISE", "name of the prefix list for -o prefix-list")
+}
+
+func Summarize(w io.Writer, inputFile, under string, tags []string, output, listName string) error {
+	if output != "plain" && output != "json" && output != "prefix-list" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain, json or prefix-list", output)
+	}
+
+	var ipam models.IPAM
+	if err := fileutil.ReadYAML(inputFile, &ipam); err != nil {
+		return err
+	}
+
+	roots := ipam.Subnets
+	if under != "" {
+		if err := subnetutils.CheckValidSubnet(under); err != nil {
+			return err
+		}
+		_, node, ok := treeutil.Find(ipam.Subnets, under)
+		if !ok {
+			return fmt.Errorf("subnet %q does not exist in IPAM data", under)
+		}
+		roots = node.Subnets
+	}
+
+	matches, err := find.Search(roots, find.Filter{Tags: tags})
+	if err != nil {
+		return err
+	}
+	nets := make([]*net.IPNet, 0, len(matches))
+	for _, match := range matches {
+		_, n, err := net.ParseCIDR(match.CIDR)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", match.CIDR, err)
+		}
+		nets = append(nets, n)
+	}
+
+	prefixes := []string{}
+	for _, n := range subnetutils.Aggregate(nets) {
+		prefixes = append(prefixes, n.String())
+	}
+
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(prefixes)
+	case "prefix-list":
+		return writePrefixList(w, listName, prefixes)
+	}
+	for _, p := range prefixes {
+		if _, err := fmt.Fprintln(w, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePrefixList prints prefixes as Cisco IOS / FRR prefix-list entries,
+// numbered in steps of 5 so entries can be inserted by hand later. IPv4 and
+// IPv6 lists are separate on the router, so each is numbered from 5.
+func writePrefixList(w io.Writer, name string, prefixes []string) error {
+	seq := map[string]int{}
+	for _, p := range prefixes {
+		_, n, _ := net.ParseCIDR(p)
+		keyword := "ip"
+		if subnetutils.Family(n) == "IPv6" {
+			keyword = "ipv6"
+		}
+		seq[keyword] += 5
+		if _, err := fmt.Fprintf(w, "%s prefix-list %s seq %d permit %s\n", keyword, name, seq[keyword], p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package summarize
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// The seed has advertised blocks that are contained in each other, adjacent
+// and aligned (so they merge), adjacent but misaligned (so they don't), and
+// an IPv6 pair that merges into its /32.
+const seedFile = "testdata/seed.yaml"
+
+func Test_Summarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		under    string
+		tags     []string
+		output   string
+		listName string
+		golden   string
+	}{
+		{name: "plain", tags: []string{"advertise"}, output: "plain", golden: "testdata/plain_expected.txt"},
+		{name: "json", tags: []string{"advertise"}, output: "json", golden: "testdata/json_expected.json"},
+		{name: "prefix-list", tags: []string{"advertise"}, output: "prefix-list", listName: "EDGE", golden: "testdata/prefix_list_expected.txt"},
+		{name: "under", under: "10.0.0.0/16", tags: []string{"!advertise"}, output: "plain", golden: "testdata/under_expected.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := Summarize(&got, seedFile, tt.under, tt.tags, tt.output, tt.listName); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, err := os.ReadFile(tt.golden)
+			if err != nil {
+				t.Fatalf("unexpected error reading fixture: %v", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+			}
+		})
+	}
+}
+
+func Test_SummarizeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		under   string
+		output  string
+		wantErr string
+	}{
+		{
+			name:    "unknown output",
+			output:  "xml",
+			wantErr: `unknown output format "xml". Must be one of plain, json or prefix-list`,
+		},
+		{
+			name:    "under not in IPAM",
+			under:   "10.9.0.0/16",
+			output:  "plain",
+			wantErr: `subnet "10.9.0.0/16" does not exist in IPAM data`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Summarize(&bytes.Buffer{}, seedFile, tt.under, nil, tt.output, "")
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
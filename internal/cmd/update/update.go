@@ -0,0 +1,152 @@
+package update
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/resultutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var subnet, inputFile, description, output string
+var tags, addTags, removeTags, reserve []string
+var clearDescription, clearReserve bool
+var opts fileutil.UpdateOptions
+
+var UpdateCmd = &cobra.Command{
+	Use:          "update",
+	Short:        "Change the description, tags or reserve rules of a subnet",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var changes Changes
+		if cmd.Flags().Changed("description") {
+			changes.Description = &description
+		}
+		if clearDescription {
+			empty := ""
+			changes.Description = &empty
+		}
+		if cmd.Flags().Changed("tags") {
+			changes.Tags = &tags
+		}
+		if cmd.Flags().Changed("reserve") {
+			changes.Reserve = &reserve
+		}
+		if clearReserve {
+			changes.Reserve = &[]string{}
+		}
+		changes.AddTags = addTags
+		changes.RemoveTags = removeTags
+		return Update(cmd.OutOrStdout(), inputFile, subnet, changes, output, opts)
+	},
+}
+
+func init() {
+	UpdateCmd.Flags().StringVarP(&subnet, "subnet", "s", "", "subnet to update")
+	UpdateCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = UpdateCmd.MarkFlagRequired("subnet")
+	_ = UpdateCmd.MarkFlagRequired("file")
+	UpdateCmd.Flags().StringVarP(&description, "description", "d", "", "new description for the subnet")
+	UpdateCmd.Flags().BoolVar(&clearDescription, "clear-description", false, "remove the subnet's description")
+	UpdateCmd.Flags().StringSliceVarP(&tags, "tags", "t", []string{}, "replace the subnet's tags with these")
+	UpdateCmd.Flags().StringSliceVar(&addTags, "add-tag", []string{}, "tags to add to the subnet")
+	UpdateCmd.Flags().StringSliceVar(&removeTags, "remove-tag", []string{}, "tags to remove from the subnet")
+	UpdateCmd.Flags().StringSliceVar(&reserve, "reserve", []string{}, "replace the subnet's reserve rules with these, e.g. first:4,last:1, or none to reserve nothing")
+	UpdateCmd.Flags().BoolVar(&clearReserve, "clear-reserve", false, "remove the subnet's reserve rules so that it inherits its parent's")
+	UpdateCmd.MarkFlagsMutuallyExclusive("description", "clear-description")
+	UpdateCmd.MarkFlagsMutuallyExclusive("reserve", "clear-reserve")
+	resultutil.AddOutputFlag(UpdateCmd.Flags(), &output)
+	fileutil.AddUpdateFlags(UpdateCmd, &opts)
+}
+
+// Changes describes the metadata edits to make. Nil pointers leave the
+// field as it is; Tags is applied before AddTags and RemoveTags. An empty
+// Reserve removes the subnet's reserve rules.
+type Changes struct {
+	Description *string
+	Tags        *[]string
+	Reserve     *[]string
+	AddTags     []string
+	RemoveTags  []string
+}
+
+// Update applies changes to subnet in inputFile and prints the updated subnet
+// in the given output format. New reserve rules may not reserve an address
+// already assigned in subnet or in a subnet under it that inherits them.
+func Update(w io.Writer, inputFile, subnet string, changes Changes, output string, opts fileutil.UpdateOptions) error {
+	err := subnetutils.CheckValidSubnet(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet: %v", err)
+	}
+	if changes.Description == nil && changes.Tags == nil && changes.Reserve == nil && len(changes.AddTags) == 0 && len(changes.RemoveTags) == 0 {
+		return fmt.Errorf("nothing to update. Use '-d', '--clear-description', '-t', '--add-tag', '--remove-tag', '--reserve' or '--clear-reserve'")
+	}
+	if err := resultutil.CheckFormat(output); err != nil {
+		return err
+	}
+	if changes.Reserve != nil {
+		if _, err := addressutil.ParseRules(*changes.Reserve); err != nil {
+			return err
+		}
+	}
+
+	var ipam models.IPAM
+	var result resultutil.Result
+	err = fileutil.UpdateYAML(inputFile, opts, &ipam, func() error {
+		level, ok := treeutil.ContainingMap(ipam.Subnets, subnet)
+		if !ok {
+			return fmt.Errorf("subnet %q does not exist in IPAM data", subnet)
+		}
+		level[subnet] = applyChanges(level[subnet], changes)
+		if changes.Reserve != nil {
+			if err := addressutil.CheckAssigned(ipam.Subnets, subnet); err != nil {
+				return fmt.Errorf("cannot change the reserve rules of %s: %v", subnet, err)
+			}
+		}
+		path, _, _ := treeutil.Find(ipam.Subnets, subnet)
+		result = resultutil.Result{Action: resultutil.Updated, CIDR: subnet, Parents: path, Renested: []string{}}
+		if result.Parents == nil {
+			result.Parents = []string{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return resultutil.Write(w, output, []resultutil.Result{result})
+}
+
+// applyChanges returns node with changes applied; its subnets are untouched.
+func applyChanges(node models.Subnets, changes Changes) models.Subnets {
+	if changes.Description != nil {
+		node.Description = *changes.Description
+	}
+	if changes.Reserve != nil {
+		node.Reserve = slices.Clone(*changes.Reserve)
+	}
+
+	tags := slices.Clone(node.Tags)
+	if changes.Tags != nil {
+		tags = slices.Clone(*changes.Tags)
+	}
+	for _, tag := range changes.AddTags {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	tags = slices.DeleteFunc(tags, func(tag string) bool {
+		return slices.Contains(changes.RemoveTags, tag)
+	})
+	if tags == nil {
+		tags = []string{}
+	}
+	node.Tags = tags
+	return node
+}
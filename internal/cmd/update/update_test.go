@@ -0,0 +1,260 @@
+package update
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/testutils"
+)
+
+func Test_Update(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testUpdate.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	// Edit the parent so the golden file also shows its child is untouched.
+	description := "renamed"
+	changes := Changes{
+		Description: &description,
+		AddTags:     []string{"tag_3", "tag_1"},
+		RemoveTags:  []string{"tag_2"},
+	}
+	if err = Update(io.Discard, testFile, "10.10.0.0/20", changes, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/update_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_UpdateReplaceTagsAndClearDescription(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testUpdateReplace.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	empty := ""
+	tags := []string{"prod"}
+	var out bytes.Buffer
+	if err = Update(&out, testFile, "10.10.0.0/24", Changes{Description: &empty, Tags: &tags}, "json", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantOut, err := os.ReadFile("testdata/update_replace_expected.json")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if out.String() != string(wantOut) {
+		t.Errorf("got output:\n%s\nwant:\n%s", out.String(), wantOut)
+	}
+
+	want, err := os.ReadFile("testdata/update_replace_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_UpdateReserve(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testUpdateReserve.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	reserve := []string{"first:4", "last:1"}
+	if err = Update(io.Discard, testFile, "10.10.0.0/20", Changes{Reserve: &reserve}, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ipam models.IPAM
+	if err = fileutil.ReadYAML(testFile, &ipam); err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if got := ipam.Subnets["10.10.0.0/20"].Reserve; !slices.Equal(got, reserve) {
+		t.Errorf("got reserve %v, want %v", got, reserve)
+	}
+
+	// An empty list clears the rules again.
+	if err = Update(io.Discard, testFile, "10.10.0.0/20", Changes{Reserve: &[]string{}}, "plain", fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if strings.Contains(string(got), "reserve") {
+		t.Errorf("reserve rules not cleared:\n%s", got)
+	}
+}
+
+func Test_UpdateErrors(t *testing.T) {
+	testFile, err := testutils.CreateTestFile("testUpdateErrors.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error creating test file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	description := "x"
+	reserve := []string{"first:4", "last"}
+	tests := []struct {
+		name    string
+		subnet  string
+		changes Changes
+		wantErr string
+	}{
+		{
+			name:    "subnet not in IPAM",
+			subnet:  "10.10.1.0/24",
+			changes: Changes{Description: &description},
+			wantErr: `subnet "10.10.1.0/24" does not exist in IPAM data`,
+		},
+		{
+			name:    "invalid notation",
+			subnet:  "10.10.0.1/24",
+			changes: Changes{Description: &description},
+			wantErr: "invalid subnet: 10.10.0.1/24 is not valid CIDR notation",
+		},
+		{
+			name:    "invalid reserve rule",
+			subnet:  "10.10.0.0/24",
+			changes: Changes{Reserve: &reserve},
+			wantErr: `invalid reserve rule "last". Must be first:N, last:N or none`,
+		},
+		{
+			name:    "nothing to update",
+			subnet:  "10.10.0.0/24",
+			wantErr: "nothing to update. Use '-d', '--clear-description', '-t', '--add-tag', '--remove-tag', '--reserve' or '--clear-reserve'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Update(io.Discard, testFile, tt.subnet, tt.changes, "plain", fileutil.UpdateOptions{})
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+const reserveSeed = `description: ""
+subnets:
+    10.0.0.0/16:
+        description: ""
+        tags: []
+        subnets:
+            10.0.0.0/24:
+                description: inherits
+                tags: []
+                addresses:
+                    10.0.0.2:
+                        hostname: gw
+                subnets: {}
+            10.0.1.0/24:
+                description: own rules
+                tags: []
+                reserve:
+                    - none
+                addresses:
+                    10.0.1.0:
+                        hostname: anycast
+                    10.0.1.5:
+                        hostname: web
+                subnets: {}
+`
+
+func Test_UpdateReserveAssigned(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		changes Changes
+		wantErr string
+	}{
+		{
+			name:    "covers an address in the subnet",
+			subnet:  "10.0.1.0/24",
+			changes: Changes{Reserve: &[]string{"first:8"}},
+			wantErr: "cannot change the reserve rules of 10.0.1.0/24: 10.0.1.0 is assigned in 10.0.1.0/24 but would be reserved; release it first",
+		},
+		{
+			name:    "covers an address in an inheriting subnet",
+			subnet:  "10.0.0.0/16",
+			changes: Changes{Reserve: &[]string{"first:4"}},
+			wantErr: "cannot change the reserve rules of 10.0.0.0/16: 10.0.0.2 is assigned in 10.0.0.0/24 but would be reserved; release it first",
+		},
+		{
+			name:    "clearing brings back the default",
+			subnet:  "10.0.1.0/24",
+			changes: Changes{Reserve: &[]string{}},
+			wantErr: "cannot change the reserve rules of 10.0.1.0/24: 10.0.1.0 is assigned in 10.0.1.0/24 but would be reserved; release it first",
+		},
+		{
+			name:    "subnets with their own rules are not affected",
+			subnet:  "10.0.0.0/16",
+			changes: Changes{Reserve: &[]string{"first:2", "last:8"}},
+		},
+		{
+			name:    "free addresses only",
+			subnet:  "10.0.1.0/24",
+			changes: Changes{Reserve: &[]string{"last:1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+			if err := os.WriteFile(testFile, []byte(reserveSeed), 0o644); err != nil {
+				t.Fatalf("unexpected error writing seed file: %v", err)
+			}
+
+			err := Update(io.Discard, testFile, tt.subnet, tt.changes, "plain", fileutil.UpdateOptions{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+			if got, _ := os.ReadFile(testFile); string(got) != reserveSeed {
+				t.Errorf("file was modified despite the error:\n%s", got)
+			}
+		})
+	}
+}
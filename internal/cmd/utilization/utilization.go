@@ -0,0 +1,210 @@
+package utilization
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+var inputFile, under, output string
+var warn, crit float64
+
+var UtilizationCmd = &cobra.Command{
+	Use:   "utilization",
+	Short: "Report how much of each subnet is allocated",
+	Long: `Report how much of each subnet is allocated.
+
+DIRECT is the share of a subnet covered by its direct children. LEAF is the
+share covered by the subnets at the bottom of its tree, i.e. the ones with no
+children of their own. A subnet at the bottom of the tree that has addresses
+assigned with assign-ip, or reserve rules of its own or inherited, counts its
+reserved and assigned addresses as used instead. Thresholds are checked
+against DIRECT: the command exits 3 if any subnet reaches --warn and 4 if any
+subnet reaches --crit.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Utilization(cmd.OutOrStdout(), inputFile, under, warn, crit, output)
+	},
+}
+
+func init() {
+	UtilizationCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = UtilizationCmd.MarkFlagRequired("file")
+	UtilizationCmd.Flags().StringVarP(&under, "under", "u", "", "only report on this subnet and the subnets under it")
+	UtilizationCmd.Flags().Float64Var(&warn, "warn", 0, "warning threshold in percent (0 to disable)")
+	UtilizationCmd.Flags().Float64Var(&crit, "crit", 0, "critical threshold in percent (0 to disable)")
+	UtilizationCmd.Flags().StringVarP(&output, "output", "o", "table", "output format: table or json")
+}
+
+// Usage is the utilization of one subnet.
+type Usage struct {
+	CIDR          string   `json:"cidr"`
+	Description   string   `json:"description"`
+	Size          *big.Int `json:"size"`
+	DirectUsed    *big.Int `json:"direct_used"`
+	DirectPercent float64  `json:"direct_percent"`
+	LeafUsed      *big.Int `json:"leaf_used"`
+	LeafPercent   float64  `json:"leaf_percent"`
+	Status        string   `json:"status"`
+}
+
+func Utilization(w io.Writer, inputFile, under string, warn, crit float64, output string) error {
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown output format %q. Must be one of table or json", output)
+	}
+	if warn < 0 || warn > 100 || crit < 0 || crit > 100 {
+		return fmt.Errorf("thresholds must be between 0 and 100")
+	}
+	if warn > 0 && crit > 0 && warn > crit {
+		return fmt.Errorf("warning threshold %g%% is above critical threshold %g%%", warn, crit)
+	}
+
+	var ipam models.IPAM
+	if err := fileutil.ReadYAML(inputFile, &ipam); err != nil {
+		return err
+	}
+
+	roots := ipam.Subnets
+	if under != "" {
+		if err := subnetutils.CheckValidSubnet(under); err != nil {
+			return err
+		}
+		_, node, ok := treeutil.Find(ipam.Subnets, under)
+		if !ok {
+			return fmt.Errorf("subnet %q does not exist in IPAM data", under)
+		}
+		roots = map[string]models.Subnets{under: node}
+	}
+
+	usages := []Usage{}
+	var warned, critical int
+	err := treeutil.Walk(roots, func(path []string, cidr string, node models.Subnets) error {
+		u, err := measure(ipam.Subnets, cidr, node)
+		if err != nil {
+			return err
+		}
+		switch {
+		case crit > 0 && u.DirectPercent >= crit:
+			u.Status = "crit"
+			critical++
+		case warn > 0 && u.DirectPercent >= warn:
+			u.Status = "warn"
+			warned++
+		default:
+			u.Status = "ok"
+		}
+		usages = append(usages, u)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := write(w, usages, output); err != nil {
+		return err
+	}
+
+	if critical > 0 {
+		return exitutil.New(exitutil.Critical, fmt.Errorf("%d subnet(s) at or above the critical threshold of %g%%", critical, crit))
+	}
+	if warned > 0 {
+		return exitutil.New(exitutil.Warning, fmt.Errorf("%d subnet(s) at or above the warning threshold of %g%%", warned, warn))
+	}
+	return nil
+}
+
+// measure computes the direct and leaf utilization of one subnet of tree.
+func measure(tree map[string]models.Subnets, cidr string, node models.Subnets) (Usage, error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return Usage{}, fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+	}
+
+	var direct, leaves []*net.IPNet
+	for child := range node.Subnets {
+		_, c, err := net.ParseCIDR(child)
+		if err != nil {
+			return Usage{}, fmt.Errorf("corrupt IPAM: %q: %w", child, err)
+		}
+		direct = append(direct, c)
+	}
+	err = treeutil.Walk(node.Subnets, func(path []string, cidr string, node models.Subnets) error {
+		if len(node.Subnets) > 0 {
+			return nil
+		}
+		_, leaf, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		leaves = append(leaves, leaf)
+		return nil
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+
+	u := Usage{
+		CIDR:        cidr,
+		Description: node.Description,
+		Size:        subnetutils.NetworkSize(n),
+		DirectUsed:  subnetutils.Covered(n, direct),
+		LeafUsed:    subnetutils.Covered(n, leaves),
+	}
+	if len(node.Subnets) == 0 {
+		policy, err := addressutil.Effective(tree, cidr)
+		if err != nil {
+			return Usage{}, fmt.Errorf("corrupt IPAM: %w", err)
+		}
+		if policy.Explicit || len(node.Addresses) > 0 {
+			u.DirectUsed = hostsUsed(n, node, policy)
+			u.LeafUsed = u.DirectUsed
+		}
+	}
+	u.DirectPercent = subnetutils.Percent(u.DirectUsed, u.Size)
+	u.LeafPercent = subnetutils.Percent(u.LeafUsed, u.Size)
+	return u, nil
+}
+
+// hostsUsed counts the addresses of the leaf subnet n that policy reserves
+// or that are assigned in node, each once.
+func hostsUsed(n *net.IPNet, node models.Subnets, policy addressutil.Policy) *big.Int {
+	used := policy.Count(n)
+	one := big.NewInt(1)
+	for ip := range node.Addresses {
+		if parsed := net.ParseIP(ip); parsed != nil && n.Contains(parsed) && !policy.Contains(n, parsed) {
+			used.Add(used, one)
+		}
+	}
+	return used
+}
+
+func write(w io.Writer, usages []Usage, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(usages)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "CIDR\tSIZE\tDIRECT\tLEAF\tSTATUS\tDESCRIPTION"); err != nil {
+		return err
+	}
+	for _, u := range usages {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%.2f%%\t%.2f%%\t%s\t%s\n", u.CIDR, u.Size, u.DirectPercent, u.LeafPercent, u.Status, u.Description); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,189 @@
+package utilization
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
+)
+
+const seedFile = "testdata/seed.yaml"
+
+func Test_Utilization(t *testing.T) {
+	tests := []struct {
+		name   string
+		under  string
+		output string
+		golden string
+	}{
+		{name: "table", output: "table", golden: "testdata/table_expected.txt"},
+		{name: "json under", under: "10.0.0.0/24", output: "json", golden: "testdata/json_expected.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := Utilization(&got, seedFile, tt.under, 0, 0, tt.output); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, err := os.ReadFile(tt.golden)
+			if err != nil {
+				t.Fatalf("unexpected error reading fixture: %v", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+			}
+		})
+	}
+}
+
+// 10.0.0.0/16 is 50.78% used by its direct children and 10.0.0.0/24 is 25%,
+// so the thresholds below pick out one, both or neither of them.
+func Test_UtilizationThresholds(t *testing.T) {
+	tests := []struct {
+		name     string
+		warn     float64
+		crit     float64
+		wantCode int
+		wantErr  string
+	}{
+		{
+			name:     "critical wins over warning",
+			warn:     25,
+			crit:     50,
+			wantCode: exitutil.Critical,
+			wantErr:  "1 subnet(s) at or above the critical threshold of 50%",
+		},
+		{
+			name:     "warning only",
+			warn:     20,
+			wantCode: exitutil.Warning,
+			wantErr:  "2 subnet(s) at or above the warning threshold of 20%",
+		},
+		{
+			name: "below thresholds",
+			warn: 60,
+			crit: 90,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Utilization(&out, seedFile, "", tt.warn, tt.crit, "table")
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var exitErr *exitutil.Error
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("expected exit error, got %v", err)
+			}
+			if exitErr.Code != tt.wantCode {
+				t.Errorf("got exit code %d, want %d", exitErr.Code, tt.wantCode)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+// A leaf with reserve rules counts its reserved and assigned addresses as
+// used: five reserved by the rules inherited from the /24 plus 10.0.0.5, with
+// 10.0.0.1 counted once as it is both reserved and assigned.
+func Test_UtilizationHostAddresses(t *testing.T) {
+	seed := `description: ""
+subnets:
+    10.0.0.0/24:
+        description: vpc
+        tags: []
+        reserve:
+            - first:4
+            - last:1
+        subnets:
+            10.0.0.0/28:
+                description: hosts
+                tags: []
+                addresses:
+                    10.0.0.1:
+                        hostname: gw
+                    10.0.0.5:
+                        hostname: web-1
+                subnets: {}
+`
+	testFile := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(testFile, []byte(seed), 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Utilization(&out, testFile, "10.0.0.0/28", 0, 0, "json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var usages []Usage
+	if err := json.Unmarshal(out.Bytes(), &usages); err != nil {
+		t.Fatalf("unexpected error decoding output: %v", err)
+	}
+	if len(usages) != 1 {
+		t.Fatalf("got %d usages, want 1", len(usages))
+	}
+	if u := usages[0]; u.DirectUsed.Int64() != 6 || u.LeafUsed.Int64() != 6 || u.DirectPercent != 37.5 {
+		t.Errorf("got direct %s (%g%%) and leaf %s, want 6 (37.5%%) and 6", u.DirectUsed, u.DirectPercent, u.LeafUsed)
+	}
+}
+
+func Test_UtilizationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		under   string
+		warn    float64
+		crit    float64
+		output  string
+		wantErr string
+	}{
+		{
+			name:    "unknown output",
+			output:  "csv",
+			wantErr: `unknown output format "csv". Must be one of table or json`,
+		},
+		{
+			name:    "threshold out of range",
+			crit:    101,
+			output:  "table",
+			wantErr: "thresholds must be between 0 and 100",
+		},
+		{
+			name:    "warning above critical",
+			warn:    90,
+			crit:    80,
+			output:  "table",
+			wantErr: "warning threshold 90% is above critical threshold 80%",
+		},
+		{
+			name:    "under not in IPAM",
+			under:   "192.168.0.0/24",
+			output:  "table",
+			wantErr: `subnet "192.168.0.0/24" does not exist in IPAM data`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Utilization(&out, seedFile, tt.under, tt.warn, tt.crit, tt.output)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+// flatEntry is one subnet pulled out of the document with its metadata.
+type flatEntry struct {
+	net         *net.IPNet
+	name        string
+	description string
+	tags        []string
+	reserve     []string
+	addresses   map[string]models.Address
+}
+
+// Repair rebuilds the hierarchy of a decoded IPAM document from the flat set
+// of CIDRs it contains. Keys are canonicalized, every subnet is nested under
+// its smallest enclosing subnet and duplicates are merged, keeping the first
+// non-empty description and reserve rules and the union of tags and of
+// addresses. Problems that need a human (keys that are not CIDRs, values that
+// are not mappings, reserve rules that do not parse, addresses that are
+// invalid or not in a leaf subnet, before or after the rebuild) are returned
+// as an error instead.
+func Repair(root *yaml.Node, issues []Issue) (*models.IPAM, error) {
+	var unfixable int
+	for _, issue := range issues {
+		switch issue.Kind {
+		case InvalidCIDR, BadStructure, InvalidReserve, InvalidAddress, NonLeafAddress:
+			unfixable++
+		}
+	}
+	if unfixable > 0 {
+		return nil, fmt.Errorf("cannot fix: %d problem(s) must be corrected by hand", unfixable)
+	}
+
+	doc := root
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+
+	ipam := &models.IPAM{Subnets: map[string]models.Subnets{}}
+	if d := mappingValue(doc, "description"); d != nil {
+		ipam.Description = d.Value
+	}
+
+	var entries []*flatEntry
+	byCIDR := map[string]*flatEntry{}
+	if err := flatten(mappingValue(doc, "subnets"), &entries, byCIDR); err != nil {
+		return nil, err
+	}
+
+	flat := make(map[string]models.Subnets, len(entries))
+	for _, e := range entries {
+		flat[e.net.String()] = models.Subnets{Name: e.name, Description: e.description, Tags: e.tags, Reserve: e.reserve, Addresses: e.addresses}
+	}
+	subnets, err := treeutil.Build(flat)
+	if err != nil {
+		return nil, err
+	}
+	if err := treeutil.CheckLeafAddresses(subnets); err != nil {
+		return nil, fmt.Errorf("cannot fix: %v", err)
+	}
+	ipam.Subnets = subnets
+	return ipam, nil
+}
+
+func flatten(m *yaml.Node, entries *[]*flatEntry, byCIDR map[string]*flatEntry) error {
+	if isNull(m) {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		_, n, err := net.ParseCIDR(key.Value)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", key.Value, err)
+		}
+
+		var meta struct {
+			Name        string
+			Description string
+			Tags        []string
+			Reserve     []string
+			Addresses   map[string]models.Address
+		}
+		if err := value.Decode(&meta); err != nil {
+			return fmt.Errorf("error decoding %s at line %d: %v", key.Value, key.Line, err)
+		}
+
+		if e, ok := byCIDR[n.String()]; ok {
+			if e.name == "" {
+				e.name = meta.Name
+			}
+			if e.description == "" {
+				e.description = meta.Description
+			}
+			if len(e.reserve) == 0 {
+				e.reserve = meta.Reserve
+			}
+			for _, tag := range meta.Tags {
+				if !slices.Contains(e.tags, tag) {
+					e.tags = append(e.tags, tag)
+				}
+			}
+			for ip, a := range meta.Addresses {
+				if _, ok := e.addresses[ip]; !ok {
+					if e.addresses == nil {
+						e.addresses = map[string]models.Address{}
+					}
+					e.addresses[ip] = a
+				}
+			}
+		} else {
+			e := &flatEntry{net: n, name: meta.Name, description: meta.Description, tags: meta.Tags, reserve: meta.Reserve, addresses: meta.Addresses}
+			if e.tags == nil {
+				e.tags = []string{}
+			}
+			byCIDR[n.String()] = e
+			*entries = append(*entries, e)
+		}
+
+		if err := flatten(mappingValue(value, "subnets"), entries, byCIDR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/addressutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/diffutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+)
+
+var inputFile, output string
+var fix bool
+var opts fileutil.UpdateOptions
+
+var ValidateCmd = &cobra.Command{
+	Use:          "validate",
+	Aliases:      []string{"fsck"},
+	Short:        "Check an IPAM file for corruption",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return Validate(cmd.OutOrStdout(), inputFile, output, fix, opts)
+	},
+}
+
+func init() {
+	ValidateCmd.Flags().StringVarP(&inputFile, "file", "f", "", "ipam file")
+	_ = ValidateCmd.MarkFlagRequired("file")
+	ValidateCmd.Flags().StringVarP(&output, "output", "o", "plain", "output format: plain or json")
+	ValidateCmd.Flags().BoolVar(&fix, "fix", false, "rebuild the hierarchy, canonicalize keys and write the result, printing a diff of the changes (to stderr with -o json)")
+	fileutil.AddUpdateFlags(ValidateCmd, &opts)
+}
+
+// Kinds of problem Check reports.
+const (
+	InvalidCIDR    = "invalid-cidr"
+	NonCanonical   = "non-canonical"
+	NotContained   = "not-contained"
+	Overlap        = "overlap"
+	Misnested      = "misnested"
+	Duplicate      = "duplicate"
+	NilSubnets     = "nil-subnets"
+	BadStructure   = "bad-structure"
+	InvalidReserve = "invalid-reserve"
+	InvalidAddress = "invalid-address"
+	NonLeafAddress = "non-leaf-address"
+)
+
+// Issue is one problem found in an IPAM file, positioned at the YAML key
+// (or value) it concerns.
+type Issue struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Kind    string `json:"kind"`
+	CIDR    string `json:"cidr,omitempty"`
+	Message string `json:"message"`
+}
+
+// Validate checks inputFile and reports every problem found. With fix set it
+// also repairs the file, holding its lock for the whole cycle. The diff of
+// the repair goes to opts.DiffOutput, or to w for plain output if that is
+// nil.
+func Validate(w io.Writer, inputFile, output string, fix bool, opts fileutil.UpdateOptions) error {
+	if output != "plain" && output != "json" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain or json", output)
+	}
+	if opts.DryRun && !fix {
+		return fmt.Errorf("'--dry-run' only applies with '--fix'")
+	}
+
+	if fix {
+		unlock, err := fileutil.Lock(inputFile, opts.LockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	data, err := fileutil.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	if fix {
+		if err := fileutil.CheckHash(inputFile, data, opts.IfMatch); err != nil {
+			return err
+		}
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("error unmarshaling IPAM: %v", err)
+	}
+
+	issues := Check(&root)
+
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range issues {
+			if _, err := fmt.Fprintf(w, "%s:%d:%d: %s: %s\n", inputFile, issue.Line, issue.Column, issue.Kind, issue.Message); err != nil {
+				return err
+			}
+		}
+	}
+
+	if fix {
+		diffOutput := opts.DiffOutput
+		if diffOutput == nil && output == "plain" {
+			diffOutput = w
+		}
+		return repairFile(diffOutput, inputFile, data, &root, issues, opts.DryRun)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%d problem(s) found in %s", len(issues), inputFile)
+	}
+	return nil
+}
+
+// repairFile rebuilds inputFile, prints a diff against its current
+// contents to w unless w is nil and, unless dryRun is set, writes the
+// result.
+func repairFile(w io.Writer, inputFile string, data []byte, root *yaml.Node, issues []Issue, dryRun bool) error {
+	ipam, err := Repair(root, issues)
+	if err != nil {
+		return err
+	}
+
+	repaired, err := yaml.Marshal(ipam)
+	if err != nil {
+		return fmt.Errorf("error marshaling YAML: %v", err)
+	}
+	diff := diffutil.Unified(inputFile, inputFile+" (fixed)", data, repaired)
+	if diff == "" {
+		return nil
+	}
+	if w != nil {
+		if _, err := io.WriteString(w, diff); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	return fileutil.WriteYAMLAtomic(inputFile, ipam)
+}
+
+// entry is a subnet key that parsed, remembered for sibling and duplicate checks.
+type entry struct {
+	key    *yaml.Node
+	net    *net.IPNet
+	parent string
+}
+
+// checker accumulates issues while walking the document.
+type checker struct {
+	issues []Issue
+	seen   map[string]entry
+}
+
+// Check walks a decoded IPAM document and returns every problem it finds,
+// in file order.
+func Check(root *yaml.Node) []Issue {
+	c := &checker{issues: []Issue{}, seen: map[string]entry{}}
+
+	doc := root
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		c.add(doc, BadStructure, "", "top level of an IPAM file must be a mapping")
+		return c.issues
+	}
+
+	subnets := mappingValue(doc, "subnets")
+	if isNull(subnets) {
+		c.add(doc, NilSubnets, "", "IPAM file has no subnets map")
+		return c.issues
+	}
+	c.checkLevel(subnets, nil, "")
+
+	slices.SortStableFunc(c.issues, func(a, b Issue) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	return c.issues
+}
+
+// checkLevel checks the subnets mapping m, whose nearest valid ancestor is
+// parent (nil at the top level).
+func (c *checker) checkLevel(m *yaml.Node, parent *net.IPNet, parentKey string) {
+	if m.Kind != yaml.MappingNode {
+		c.add(m, BadStructure, "", "subnets must be a mapping")
+		return
+	}
+
+	var siblings []entry
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		cidr := key.Value
+		nearest := parent
+
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			c.add(key, InvalidCIDR, cidr, fmt.Sprintf("%s is not a valid CIDR", cidr))
+		} else {
+			nearest = n
+			if n.String() != cidr {
+				c.add(key, NonCanonical, cidr, fmt.Sprintf("%s has host bits set; did you mean %s", cidr, n))
+			}
+			if parent != nil && !contains(parent, n) {
+				c.add(key, NotContained, cidr, fmt.Sprintf("%s is not inside its parent %s", cidr, parentKey))
+			}
+			e := entry{key: key, net: n, parent: parentKey}
+			siblings = append(siblings, e)
+			if first, ok := c.seen[n.String()]; ok && first.parent != parentKey {
+				c.add(key, Duplicate, cidr, fmt.Sprintf("%s is also defined at line %d", cidr, first.key.Line))
+			} else if !ok {
+				c.seen[n.String()] = e
+			}
+		}
+
+		if value.Kind != yaml.MappingNode {
+			c.add(value, BadStructure, cidr, fmt.Sprintf("%s must be a mapping", cidr))
+			continue
+		}
+		if reserve := mappingValue(value, "reserve"); reserve != nil {
+			c.checkReserve(reserve, cidr)
+		}
+		children := mappingValue(value, "subnets")
+		if addresses := mappingValue(value, "addresses"); !isNull(addresses) {
+			var own *net.IPNet
+			if nearest != parent {
+				own = nearest
+			}
+			c.checkAddresses(addresses, children, cidr, own)
+		}
+		if isNull(children) {
+			c.add(key, NilSubnets, cidr, fmt.Sprintf("%s has no subnets map", cidr))
+			continue
+		}
+		childParentKey := cidr
+		if nearest == parent {
+			childParentKey = parentKey
+		}
+		c.checkLevel(children, nearest, childParentKey)
+	}
+
+	for i, a := range siblings {
+		for _, b := range siblings[i+1:] {
+			c.checkSiblings(a, b)
+		}
+	}
+}
+
+// checkReserve checks the reserve rules of the subnet cidr.
+func (c *checker) checkReserve(reserve *yaml.Node, cidr string) {
+	var rules []string
+	if err := reserve.Decode(&rules); err != nil {
+		c.add(reserve, InvalidReserve, cidr, fmt.Sprintf("reserve of %s must be a list of rules", cidr))
+		return
+	}
+	if _, err := addressutil.ParseRules(rules); err != nil {
+		c.add(reserve, InvalidReserve, cidr, fmt.Sprintf("%s: %v", cidr, err))
+	}
+}
+
+// checkAddresses checks the addresses assigned in the subnet cidr, parsed as
+// n (nil if it does not parse): each must be a canonical address inside n,
+// and only a leaf subnet may hold any.
+func (c *checker) checkAddresses(addresses, children *yaml.Node, cidr string, n *net.IPNet) {
+	if addresses.Kind != yaml.MappingNode {
+		c.add(addresses, BadStructure, cidr, fmt.Sprintf("addresses of %s must be a mapping", cidr))
+		return
+	}
+	if len(addresses.Content) == 0 {
+		return
+	}
+	if children != nil && children.Kind == yaml.MappingNode && len(children.Content) > 0 {
+		c.add(addresses.Content[0], NonLeafAddress, cidr, fmt.Sprintf("%s has subnets under it and %d address(es) assigned; addresses belong in leaf subnets", cidr, len(addresses.Content)/2))
+	}
+	if n == nil {
+		return
+	}
+	for i := 0; i+1 < len(addresses.Content); i += 2 {
+		key := addresses.Content[i]
+		if _, err := addressutil.ParseIP(key.Value, n); err != nil {
+			c.add(key, InvalidAddress, cidr, fmt.Sprintf("%s: %v", cidr, err))
+		}
+	}
+}
+
+// checkSiblings reports two subnets at the same level that cover the same
+// space, or where one should be nested under the other.
+func (c *checker) checkSiblings(a, b entry) {
+	aOnes, _ := a.net.Mask.Size()
+	bOnes, _ := b.net.Mask.Size()
+	switch {
+	case a.net.String() == b.net.String():
+		c.add(b.key, Overlap, b.key.Value, fmt.Sprintf("%s overlaps sibling %s at line %d", b.key.Value, a.key.Value, a.key.Line))
+	case aOnes < bOnes && a.net.Contains(b.net.IP):
+		c.add(b.key, Misnested, b.key.Value, fmt.Sprintf("%s belongs under sibling %s", b.key.Value, a.key.Value))
+	case bOnes < aOnes && b.net.Contains(a.net.IP):
+		c.add(a.key, Misnested, a.key.Value, fmt.Sprintf("%s belongs under sibling %s", a.key.Value, b.key.Value))
+	}
+}
+
+func (c *checker) add(n *yaml.Node, kind, cidr, message string) {
+	c.issues = append(c.issues, Issue{Line: n.Line, Column: n.Column, Kind: kind, CIDR: cidr, Message: message})
+}
+
+// contains reports whether child lies strictly inside parent.
+func contains(parent, child *net.IPNet) bool {
+	parentOnes, parentBits := parent.Mask.Size()
+	childOnes, childBits := child.Mask.Size()
+	return parentBits == childBits && childOnes > parentOnes && parent.Contains(child.IP)
+}
+
+// mappingValue returns the value stored under key in mapping m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func isNull(n *yaml.Node) bool {
+	return n == nil || (n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null")
+}
@@ -0,0 +1,212 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/fileutil"
+)
+
+func Test_Validate(t *testing.T) {
+	var got bytes.Buffer
+	err := Validate(&got, "testdata/corrupt.yaml", "plain", false, fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	wantErr := "8 problem(s) found in testdata/corrupt.yaml"
+	if err.Error() != wantErr {
+		t.Errorf("got error %q, want %q", err.Error(), wantErr)
+	}
+
+	want, err := os.ReadFile("testdata/corrupt_expected.txt")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+func Test_ValidateClean(t *testing.T) {
+	var got bytes.Buffer
+	if err := Validate(&got, "testdata/valid.yaml", "json", false, fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.String() != "[]\n" {
+		t.Errorf("got %q, want empty JSON list", got.String())
+	}
+}
+
+func Test_ValidateStructure(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "no subnets at top level",
+			content: "description: empty\n",
+			want:    "testValidateStructure.yaml:1:1: nil-subnets: IPAM file has no subnets map\n",
+		},
+		{
+			name:    "top level not a mapping",
+			content: "- 10.0.0.0/8\n",
+			want:    "testValidateStructure.yaml:1:1: bad-structure: top level of an IPAM file must be a mapping\n",
+		},
+		{
+			name:    "subnet not a mapping",
+			content: "subnets:\n    10.0.0.0/8: oops\n",
+			want:    "testValidateStructure.yaml:2:17: bad-structure: 10.0.0.0/8 must be a mapping\n",
+		},
+		{
+			name:    "invalid reserve rule",
+			content: "subnets:\n    10.0.0.0/8:\n        reserve: [first:2, middle:1]\n        subnets: {}\n",
+			want:    "testValidateStructure.yaml:3:18: invalid-reserve: 10.0.0.0/8: invalid reserve rule \"middle:1\". Must be first:N, last:N or none\n",
+		},
+		{
+			name:    "address outside its subnet",
+			content: "subnets:\n    10.0.0.0/24:\n        addresses:\n            10.0.1.5: {}\n        subnets: {}\n",
+			want:    "testValidateStructure.yaml:4:13: invalid-address: 10.0.0.0/24: 10.0.1.5 is not inside 10.0.0.0/24\n",
+		},
+		{
+			name:    "addresses on a subnet with subnets under it",
+			content: "subnets:\n    10.0.0.0/24:\n        addresses:\n            10.0.0.5: {}\n        subnets:\n            10.0.0.0/26:\n                subnets: {}\n",
+			want:    "testValidateStructure.yaml:4:13: non-leaf-address: 10.0.0.0/24 has subnets under it and 1 address(es) assigned; addresses belong in leaf subnets\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFile := "testValidateStructure.yaml"
+			if err := os.WriteFile(testFile, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("unexpected error writing seed file: %v", err)
+			}
+			t.Cleanup(func() { _ = os.Remove(testFile) })
+
+			var got bytes.Buffer
+			if err := Validate(&got, testFile, "plain", false, fileutil.UpdateOptions{}); err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_ValidateFix(t *testing.T) {
+	seed, err := os.ReadFile("testdata/fixable.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading seed: %v", err)
+	}
+	testFile := "testValidateFix.yaml"
+	if err := os.WriteFile(testFile, seed, 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	wantOut, err := os.ReadFile("testdata/fix_output_expected.txt")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+
+	// A dry run prints the same diff but leaves the file alone.
+	var out bytes.Buffer
+	if err := Validate(&out, testFile, "plain", true, fileutil.UpdateOptions{DryRun: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != string(wantOut) {
+		t.Errorf("got dry-run output:\n%s\nwant:\n%s", out.String(), wantOut)
+	}
+	if got, _ := os.ReadFile(testFile); string(got) != string(seed) {
+		t.Errorf("dry run modified the file:\n%s", got)
+	}
+
+	out.Reset()
+	if err := Validate(&out, testFile, "plain", true, fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != string(wantOut) {
+		t.Errorf("got output:\n%s\nwant:\n%s", out.String(), wantOut)
+	}
+
+	want, err := os.ReadFile("testdata/fix_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A repaired file is clean and a second fix is a no-op.
+	out.Reset()
+	if err := Validate(&out, testFile, "plain", true, fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output on second fix, got:\n%s", out.String())
+	}
+}
+
+// With JSON output the fix prints only the issues, so the output stays
+// parseable; the diff is left out.
+func Test_ValidateFixJSON(t *testing.T) {
+	seed, err := os.ReadFile("testdata/fixable.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading seed: %v", err)
+	}
+	testFile := "testValidateFixJSON.yaml"
+	if err := os.WriteFile(testFile, seed, 0o644); err != nil {
+		t.Fatalf("unexpected error writing seed file: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(testFile) })
+
+	var out bytes.Buffer
+	if err := Validate(&out, testFile, "json", true, fileutil.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var issues []Issue
+	if err := json.Unmarshal(out.Bytes(), &issues); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	if len(issues) == 0 {
+		t.Errorf("expected issues in output")
+	}
+
+	want, err := os.ReadFile("testdata/fix_expected.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error reading fixture: %v", err)
+	}
+	if got, _ := os.ReadFile(testFile); string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_ValidateDryRunNeedsFix(t *testing.T) {
+	var out bytes.Buffer
+	err := Validate(&out, "testdata/valid.yaml", "plain", false, fileutil.UpdateOptions{DryRun: true})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := "'--dry-run' only applies with '--fix'"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
+
+func Test_ValidateFixUnfixable(t *testing.T) {
+	var out bytes.Buffer
+	err := Validate(&out, "testdata/corrupt.yaml", "plain", true, fileutil.UpdateOptions{})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	wantErr := "cannot fix: 1 problem(s) must be corrected by hand"
+	if err.Error() != wantErr {
+		t.Errorf("got error %q, want %q", err.Error(), wantErr)
+	}
+}
@@ -6,7 +6,24 @@ type IPAM struct {
 }
 
 type Subnets struct {
+	// Name is an optional stable identifier, used to recognise a named
+	// allocation when it is requested again.
+	Name        string `yaml:",omitempty"`
 	Description string
 	Tags        []string
-	Subnets     map[string]Subnets
+	// Reserve lists the rules, such as first:4 and last:1, for the addresses
+	// that cannot be assigned to hosts in this subnet and the subnets under
+	// it that have no rules of their own.
+	Reserve []string `yaml:",omitempty"`
+	// Addresses holds the host addresses assigned in the subnet, keyed by IP.
+	Addresses map[string]Address `yaml:",omitempty"`
+	Subnets   map[string]Subnets
+}
+
+// Address is a host address assigned in a subnet.
+type Address struct {
+	Hostname    string   `yaml:",omitempty"`
+	Description string   `yaml:",omitempty"`
+	Tags        []string `yaml:",omitempty"`
+	MAC         string   `yaml:"mac,omitempty"`
 }
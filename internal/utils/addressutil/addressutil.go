@@ -0,0 +1,98 @@
+package addressutil
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+// Locate finds subnet in tree and returns the map holding it and its parsed
+// network.
+func Locate(tree map[string]models.Subnets, subnet string) (map[string]models.Subnets, *net.IPNet, error) {
+	if err := subnetutils.CheckValidSubnet(subnet); err != nil {
+		return nil, nil, fmt.Errorf("invalid subnet: %v", err)
+	}
+	level, ok := treeutil.ContainingMap(tree, subnet)
+	if !ok {
+		return nil, nil, fmt.Errorf("subnet %q does not exist in IPAM data", subnet)
+	}
+	_, n, _ := net.ParseCIDR(subnet)
+	return level, n, nil
+}
+
+// Leaf is Locate for the assign commands: addresses are only assigned in
+// leaf subnets, so a subnet with subnets under it is an error.
+func Leaf(tree map[string]models.Subnets, subnet string) (map[string]models.Subnets, *net.IPNet, error) {
+	level, n, err := Locate(tree, subnet)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(level[subnet].Subnets) > 0 {
+		return nil, nil, fmt.Errorf("%s has subnets under it; addresses can only be assigned in a leaf subnet", subnet)
+	}
+	return level, n, nil
+}
+
+// ParseIP parses ip, which must be in canonical form and inside n.
+func ParseIP(ip string, n *net.IPNet) (net.IP, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("%q is not a valid IP address", ip)
+	}
+	if parsed.String() != ip {
+		return nil, fmt.Errorf("%s is not in canonical form; did you mean %s", ip, parsed)
+	}
+	if (parsed.To4() != nil) != (subnetutils.Family(n) == "IPv4") || !n.Contains(parsed) {
+		return nil, fmt.Errorf("%s is not inside %s", ip, n)
+	}
+	return parsed, nil
+}
+
+// CheckFree returns an error if ip cannot be assigned in node, the subnet
+// n: it is already assigned or p reserves it.
+func CheckFree(n *net.IPNet, node models.Subnets, p Policy, ip net.IP) error {
+	if a, ok := node.Addresses[ip.String()]; ok {
+		if a.Hostname != "" {
+			return fmt.Errorf("%s is already assigned to %s", ip, a.Hostname)
+		}
+		return fmt.Errorf("%s is already assigned", ip)
+	}
+	if p.Contains(n, ip) {
+		return fmt.Errorf("%s is reserved in %s", ip, n)
+	}
+	return nil
+}
+
+// Next returns the lowest address of n that is neither assigned in node
+// nor reserved by p.
+func Next(n *net.IPNet, node models.Subnets, p Policy) (net.IP, error) {
+	_, bits := n.Mask.Size()
+	start, end := subnetutils.NetworkBounds(n)
+	cursor := start.Add(start, big.NewInt(int64(p.First)))
+	end.Sub(end, big.NewInt(int64(p.Last)))
+	for one := big.NewInt(1); cursor.Cmp(end) <= 0; cursor.Add(cursor, one) {
+		ip := subnetutils.IntToIP(cursor, bits)
+		if _, taken := node.Addresses[ip.String()]; !taken {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no free address in %s", n)
+}
+
+// Record builds the address record for the assign commands, checking mac
+// and writing it in the usual lower-case, colon-separated form.
+func Record(hostname, description string, tags []string, mac string) (models.Address, error) {
+	a := models.Address{Hostname: hostname, Description: description, Tags: tags}
+	if mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return models.Address{}, fmt.Errorf("invalid MAC address: %v", err)
+		}
+		a.MAC = hw.String()
+	}
+	return a, nil
+}
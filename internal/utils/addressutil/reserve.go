@@ -0,0 +1,147 @@
+package addressutil
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+// Policy is how many addresses at the start and end of a subnet are
+// reserved and cannot be assigned to hosts.
+type Policy struct {
+	First, Last int
+	// Explicit is set when the policy comes from reserve rules in the file
+	// rather than from DefaultPolicy.
+	Explicit bool
+}
+
+// DefaultPolicy is the policy of a subnet with no reserve rules of its own
+// or inherited: the network and broadcast addresses of an IPv4 subnet and
+// the subnet-router anycast address of an IPv6 one. Point-to-point subnets
+// (/31 and /127) and single addresses reserve nothing.
+func DefaultPolicy(n *net.IPNet) Policy {
+	ones, bits := n.Mask.Size()
+	switch {
+	case bits-ones < 2:
+		return Policy{}
+	case bits == 32:
+		return Policy{First: 1, Last: 1}
+	}
+	return Policy{First: 1}
+}
+
+// ParseRules parses reserve rules: first:N and last:N reserve N addresses
+// at that end of the subnet, and none reserves nothing, which stops a
+// subnet inheriting the rules of its ancestors. A subnet's rules replace
+// DefaultPolicy, so first:4,last:1 already covers the network and broadcast
+// addresses. Each end may be given once, and none only on its own.
+func ParseRules(rules []string) (Policy, error) {
+	p := Policy{Explicit: true}
+	seen := map[string]string{}
+	for _, rule := range rules {
+		if rule == "none" {
+			if len(rules) > 1 {
+				return Policy{}, fmt.Errorf("reserve rule none cannot be combined with other rules")
+			}
+			continue
+		}
+		end, count, ok := strings.Cut(rule, ":")
+		n, err := strconv.Atoi(count)
+		if !ok || err != nil || n < 0 || (end != "first" && end != "last") {
+			return Policy{}, fmt.Errorf("invalid reserve rule %q. Must be first:N, last:N or none", rule)
+		}
+		if prev, ok := seen[end]; ok {
+			return Policy{}, fmt.Errorf("reserve rules %q and %q both set the %s addresses", prev, rule, end)
+		}
+		seen[end] = rule
+		if end == "first" {
+			p.First = n
+		} else {
+			p.Last = n
+		}
+	}
+	return p, nil
+}
+
+// Effective returns the policy that applies to subnet in tree: the rules of
+// the nearest of subnet and its ancestors that has any, or DefaultPolicy.
+func Effective(tree map[string]models.Subnets, subnet string) (Policy, error) {
+	_, n, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid subnet: %v", err)
+	}
+	path, _, ok := treeutil.Find(tree, subnet)
+	if !ok {
+		return Policy{}, fmt.Errorf("subnet %q does not exist in IPAM data", subnet)
+	}
+
+	p := DefaultPolicy(n)
+	level := tree
+	for _, cidr := range append(path, subnet) {
+		node := level[cidr]
+		if len(node.Reserve) > 0 {
+			if p, err = ParseRules(node.Reserve); err != nil {
+				return Policy{}, fmt.Errorf("%s: %v", cidr, err)
+			}
+		}
+		level = node.Subnets
+	}
+	return p, nil
+}
+
+// Contains reports whether p reserves ip in n.
+func (p Policy) Contains(n *net.IPNet, ip net.IP) bool {
+	_, bits := n.Mask.Size()
+	start, end := subnetutils.NetworkBounds(n)
+	v := subnetutils.IPToInt(ip, bits)
+	offset := new(big.Int).Sub(v, start)
+	fromEnd := new(big.Int).Sub(end, v)
+	return offset.Cmp(big.NewInt(int64(p.First))) < 0 || fromEnd.Cmp(big.NewInt(int64(p.Last))) < 0
+}
+
+// Count returns how many addresses of n p reserves.
+func (p Policy) Count(n *net.IPNet) *big.Int {
+	count := big.NewInt(int64(p.First) + int64(p.Last))
+	if size := subnetutils.NetworkSize(n); count.Cmp(size) > 0 {
+		return size
+	}
+	return count
+}
+
+// CheckAssigned returns an error if an address assigned in subnet, or in
+// any subnet under it, is reserved by the policy that applies there. It is
+// for changes to reserve rules, which reach every subnet that inherits them.
+func CheckAssigned(tree map[string]models.Subnets, subnet string) error {
+	_, node, ok := treeutil.Find(tree, subnet)
+	if !ok {
+		return fmt.Errorf("subnet %q does not exist in IPAM data", subnet)
+	}
+	check := func(cidr string, node models.Subnets) error {
+		if len(node.Addresses) == 0 {
+			return nil
+		}
+		p, err := Effective(tree, cidr)
+		if err != nil {
+			return err
+		}
+		_, n, _ := net.ParseCIDR(cidr)
+		for _, addr := range treeutil.SortedAddresses(node.Addresses) {
+			if ip := net.ParseIP(addr); ip != nil && p.Contains(n, ip) {
+				return fmt.Errorf("%s is assigned in %s but would be reserved; release it first", addr, cidr)
+			}
+		}
+		return nil
+	}
+	if err := check(subnet, node); err != nil {
+		return err
+	}
+	return treeutil.Walk(node.Subnets, func(path []string, cidr string, node models.Subnets) error {
+		return check(cidr, node)
+	})
+}
@@ -0,0 +1,224 @@
+package addressutil
+
+import (
+	"net"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+)
+
+func Test_ParseRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		want  Policy
+	}{
+		{name: "no rules", rules: nil, want: Policy{Explicit: true}},
+		{name: "first and last", rules: []string{"first:4", "last:1"}, want: Policy{First: 4, Last: 1, Explicit: true}},
+		{name: "last only", rules: []string{"last:3"}, want: Policy{Last: 3, Explicit: true}},
+		{name: "zero", rules: []string{"first:0"}, want: Policy{Explicit: true}},
+		{name: "none", rules: []string{"none"}, want: Policy{Explicit: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRules(tt.rules)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseRulesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []string
+		wantErr string
+	}{
+		{
+			name:    "unknown end",
+			rules:   []string{"middle:1"},
+			wantErr: `invalid reserve rule "middle:1". Must be first:N, last:N or none`,
+		},
+		{
+			name:    "no count",
+			rules:   []string{"first"},
+			wantErr: `invalid reserve rule "first". Must be first:N, last:N or none`,
+		},
+		{
+			name:    "negative count",
+			rules:   []string{"last:-1"},
+			wantErr: `invalid reserve rule "last:-1". Must be first:N, last:N or none`,
+		},
+		{
+			name:    "conflicting first",
+			rules:   []string{"first:1", "first:4"},
+			wantErr: `reserve rules "first:1" and "first:4" both set the first addresses`,
+		},
+		{
+			name:    "duplicate last",
+			rules:   []string{"last:1", "first:2", "last:1"},
+			wantErr: `reserve rules "last:1" and "last:1" both set the last addresses`,
+		},
+		{
+			name:    "none with others",
+			rules:   []string{"none", "first:1"},
+			wantErr: "reserve rule none cannot be combined with other rules",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRules(tt.rules)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_Effective(t *testing.T) {
+	tree := map[string]models.Subnets{
+		"10.0.0.0/16": {
+			Reserve: []string{"first:4", "last:1"},
+			Subnets: map[string]models.Subnets{
+				"10.0.0.0/24": {Subnets: map[string]models.Subnets{}},
+				"10.0.1.0/24": {Reserve: []string{"last:2"}, Subnets: map[string]models.Subnets{}},
+				"10.0.2.0/23": {
+					Reserve: []string{"none"},
+					Subnets: map[string]models.Subnets{
+						"10.0.2.0/24": {Subnets: map[string]models.Subnets{}},
+					},
+				},
+			},
+		},
+		"10.1.0.0/24":   {Subnets: map[string]models.Subnets{}},
+		"2001:db8::/64": {Subnets: map[string]models.Subnets{}},
+	}
+
+	tests := []struct {
+		name   string
+		subnet string
+		want   Policy
+	}{
+		{name: "own rules", subnet: "10.0.0.0/16", want: Policy{First: 4, Last: 1, Explicit: true}},
+		{name: "inherited", subnet: "10.0.0.0/24", want: Policy{First: 4, Last: 1, Explicit: true}},
+		{name: "nearest rules replace the ancestor's", subnet: "10.0.1.0/24", want: Policy{Last: 2, Explicit: true}},
+		{name: "none stops inheritance", subnet: "10.0.2.0/24", want: Policy{Explicit: true}},
+		{name: "IPv4 default", subnet: "10.1.0.0/24", want: Policy{First: 1, Last: 1}},
+		{name: "IPv6 default", subnet: "2001:db8::/64", want: Policy{First: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Effective(tree, tt.subnet)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_EffectiveErrors(t *testing.T) {
+	tree := map[string]models.Subnets{
+		"10.0.0.0/16": {
+			Reserve: []string{"first:1", "first:4"},
+			Subnets: map[string]models.Subnets{
+				"10.0.0.0/24": {Subnets: map[string]models.Subnets{}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		subnet  string
+		wantErr string
+	}{
+		{
+			name:    "bad rules on an ancestor",
+			subnet:  "10.0.0.0/24",
+			wantErr: `10.0.0.0/16: reserve rules "first:1" and "first:4" both set the first addresses`,
+		},
+		{
+			name:    "not in tree",
+			subnet:  "10.9.0.0/24",
+			wantErr: `subnet "10.9.0.0/24" does not exist in IPAM data`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Effective(tree, tt.subnet)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_PolicyContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet string
+		policy Policy
+		ip     string
+		want   bool
+	}{
+		{name: "network address", subnet: "10.0.0.0/24", policy: Policy{First: 1, Last: 1}, ip: "10.0.0.0", want: true},
+		{name: "broadcast address", subnet: "10.0.0.0/24", policy: Policy{First: 1, Last: 1}, ip: "10.0.0.255", want: true},
+		{name: "first host", subnet: "10.0.0.0/24", policy: Policy{First: 1, Last: 1}, ip: "10.0.0.1", want: false},
+		{name: "last of first:4", subnet: "10.0.0.0/24", policy: Policy{First: 4, Last: 1}, ip: "10.0.0.3", want: true},
+		{name: "past first:4", subnet: "10.0.0.0/24", policy: Policy{First: 4, Last: 1}, ip: "10.0.0.4", want: false},
+		{name: "inside last:2", subnet: "10.0.0.0/24", policy: Policy{Last: 2}, ip: "10.0.0.254", want: true},
+		{name: "point-to-point", subnet: "10.0.0.0/31", policy: Policy{}, ip: "10.0.0.0", want: false},
+		{name: "IPv6 anycast", subnet: "2001:db8::/64", policy: Policy{First: 1}, ip: "2001:db8::", want: true},
+		{name: "IPv6 last", subnet: "2001:db8::/64", policy: Policy{First: 1}, ip: "2001:db8::ffff:ffff:ffff:ffff", want: false},
+		{name: "more reserved than the subnet holds", subnet: "10.0.0.0/30", policy: Policy{First: 3, Last: 3}, ip: "10.0.0.3", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, n, _ := net.ParseCIDR(tt.subnet)
+			if got := tt.policy.Contains(n, net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_PolicyCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet string
+		policy Policy
+		want   int64
+	}{
+		{name: "IPv4 default", subnet: "10.0.0.0/24", policy: Policy{First: 1, Last: 1}, want: 2},
+		{name: "first and last", subnet: "10.0.0.0/24", policy: Policy{First: 4, Last: 1}, want: 5},
+		{name: "nothing", subnet: "10.0.0.0/31", policy: Policy{}, want: 0},
+		{name: "IPv6 default", subnet: "2001:db8::/64", policy: Policy{First: 1}, want: 1},
+		{name: "capped at the subnet size", subnet: "10.0.0.0/30", policy: Policy{First: 3, Last: 3}, want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, n, _ := net.ParseCIDR(tt.subnet)
+			if got := tt.policy.Count(n); got.Int64() != tt.want {
+				t.Errorf("got %s, want %d", got, tt.want)
+			}
+		})
+	}
+}
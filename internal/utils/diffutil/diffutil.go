@@ -0,0 +1,153 @@
+package diffutil
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+// edit is one line of the edit script: ' ' keeps a line, '-' removes a line
+// of from and '+' adds a line of to. ai and bi are the positions in from and
+// to before the edit is applied.
+type edit struct {
+	kind   byte
+	line   string
+	ai, bi int
+}
+
+// Unified returns a unified diff turning from into to, labelled with the
+// given names, or "" if they are identical.
+func Unified(fromName, toName string, from, to []byte) string {
+	a, b := splitLines(string(from)), splitLines(string(to))
+	edits := diffLines(a, b)
+
+	var sb strings.Builder
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(0, i-context)
+		end := i
+		for end < len(edits) {
+			if edits[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].kind == ' ' {
+				run++
+			}
+			if run == len(edits) || run-end > 2*context {
+				end = min(end+context, len(edits))
+				break
+			}
+			end = run
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&sb, edits[start:end])
+		i = end
+	}
+	return sb.String()
+}
+
+func writeHunk(sb *strings.Builder, hunk []edit) {
+	var aCount, bCount int
+	for _, e := range hunk {
+		if e.kind != '+' {
+			aCount++
+		}
+		if e.kind != '-' {
+			bCount++
+		}
+	}
+	aStart, bStart := hunk[0].ai+1, hunk[0].bi+1
+	if aCount == 0 {
+		aStart--
+	}
+	if bCount == 0 {
+		bStart--
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, e := range hunk {
+		sb.WriteByte(e.kind)
+		sb.WriteString(e.line)
+		sb.WriteByte('\n')
+	}
+}
+
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b with Myers'
+// O(ND) algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the path, then reverse it.
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{kind: ' ', line: a[x], ai: x, bi: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				edits = append(edits, edit{kind: '+', line: b[y], ai: x, bi: y})
+			} else {
+				x--
+				edits = append(edits, edit{kind: '-', line: a[x], ai: x, bi: y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	slices.Reverse(edits)
+	return edits
+}
@@ -0,0 +1,40 @@
+package diffutil
+
+import "testing"
+
+func Test_Unified(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+
+	want := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if got := Unified("old", "new", []byte(from), []byte(to)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_UnifiedIdentical(t *testing.T) {
+	if got := Unified("old", "new", []byte("a\nb\n"), []byte("a\nb\n")); got != "" {
+		t.Errorf("expected empty diff, got:\n%s", got)
+	}
+}
+
+func Test_UnifiedFromEmpty(t *testing.T) {
+	want := "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n"
+	if got := Unified("old", "new", nil, []byte("a\nb\n")); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package exitutil
+
+// Exit codes returned by the CLI. Any error that is not an *Error exits
+// with General.
+const (
+	General            = 1
+	Warning            = 3
+	Critical           = 4
+	PreconditionFailed = 5
+	Conflict           = 6
+)
+
+// Error carries the exit code the process should end with alongside the
+// error cobra prints.
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New wraps err so that the CLI exits with code.
+func New(code int, err error) error {
+	return &Error{Code: code, Err: err}
+}
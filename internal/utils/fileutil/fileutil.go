@@ -1,14 +1,156 @@
 package fileutil
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/spf13/cobra"
 	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/diffutil"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
 )
 
+// OnRead, if set, is called with the path and content hash of every IPAM
+// file read through ReadFile, so callers can report exactly what a command
+// saw.
+var OnRead func(path, hash string)
+
+// UpdateOptions controls how mutating commands update an IPAM file.
+type UpdateOptions struct {
+	// LockTimeout is how long to wait for another process to release the file.
+	LockTimeout time.Duration
+	// IfMatch, if set, is the hash the file must still have for the update
+	// to go ahead.
+	IfMatch string
+	// DryRun, if set, makes UpdateYAML print the change as a unified diff
+	// of the file instead of writing it.
+	DryRun bool
+	// DiffOutput is where a dry run prints its diff. Nil means stdout;
+	// AddUpdateFlags sets it from the command.
+	DiffOutput io.Writer
+}
+
+// AddUpdateFlags registers the flags shared by every mutating command on
+// cmd. Before cmd runs it also points opts.DiffOutput at the command's
+// output, or at its error output if the command's --output asks for
+// anything but plain, so that structured output on stdout stays parseable.
+func AddUpdateFlags(cmd *cobra.Command, opts *UpdateOptions) {
+	fs := cmd.Flags()
+	fs.DurationVar(&opts.LockTimeout, "lock-timeout", DefaultLockTimeout, "how long to wait for another process to release the ipam file")
+	fs.StringVar(&opts.IfMatch, "if-match", "", "only write if the ipam file still has this content hash")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "print the change as a diff of the ipam file instead of writing it")
+
+	preRun := cmd.PreRunE
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		opts.DiffOutput = cmd.OutOrStdout()
+		if output := cmd.Flags().Lookup("output"); output != nil && output.Value.String() != "plain" {
+			opts.DiffOutput = cmd.ErrOrStderr()
+		}
+		if preRun != nil {
+			return preRun(cmd, args)
+		}
+		return nil
+	}
+}
+
+// UpdateYAML runs a locked read-modify-write cycle on path: it takes the
+// file's lock, unmarshals the file into v, calls fn to modify v and, if fn
+// succeeds, writes v back with WriteYAMLAtomic before releasing the lock.
+// With opts.IfMatch set, nothing is written unless the file read still has
+// that hash. With opts.DryRun set, nothing is written at all and the diff of
+// the change is printed instead.
+func UpdateYAML(path string, opts UpdateOptions, v any, fn func() error) error {
+	unlock, err := Lock(path, opts.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := CheckHash(path, data, opts.IfMatch); err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error unmarshaling IPAM: %v", err)
+	}
+	if !opts.DryRun {
+		if err := fn(); err != nil {
+			return err
+		}
+		return WriteYAMLAtomic(path, v)
+	}
+
+	// Diff against the file as it would be written unchanged, so that
+	// hand-made formatting does not show up as part of the change.
+	before, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling YAML: %v", err)
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	after, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling YAML: %v", err)
+	}
+	w := opts.DiffOutput
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err = io.WriteString(w, diffutil.Unified(path, path+" (dry run)", before, after))
+	return err
+}
+
+// Hash returns the content hash of an IPAM file: the hex SHA-256 of its
+// bytes, as printed by sha256sum.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckHash fails with exit code exitutil.PreconditionFailed if ifMatch is
+// set and does not match the hash of data, the current contents of path.
+func CheckHash(path string, data []byte, ifMatch string) error {
+	if hash := Hash(data); ifMatch != "" && hash != ifMatch {
+		return exitutil.New(exitutil.PreconditionFailed, fmt.Errorf("IPAM file %s has changed: hash is %s, expected %s", path, hash, ifMatch))
+	}
+	return nil
+}
+
+// ReadFile reads the IPAM file at path and reports its hash to OnRead.
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading IPAM file: %v", err)
+	}
+	if OnRead != nil {
+		OnRead(path, Hash(data))
+	}
+	return data, nil
+}
+
+// ReadYAML reads the YAML file at path and unmarshals it into v.
+func ReadYAML(path string, v any) error {
+	data, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error unmarshaling IPAM: %v", err)
+	}
+	return nil
+}
+
 // WriteYAMLAtomic marshals v to YAML and writes it to path using a
 // temp-file-rename so that a crash mid-write cannot corrupt the original file.
 func WriteYAMLAtomic(path string, v any) error {
@@ -1,12 +1,18 @@
 package fileutil
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/spf13/cobra"
 	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/utils/exitutil"
 )
 
 func Test_WriteYAMLAtomic_HappyPath(t *testing.T) {
@@ -49,3 +55,144 @@ func Test_WriteYAMLAtomic_BadDirectory(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func Test_UpdateYAML_IfMatch(t *testing.T) {
+	type payload struct {
+		Value int `yaml:"value"`
+	}
+
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+	original := []byte("value: 1\n")
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	var p payload
+	increment := func() error { p.Value++; return nil }
+
+	err := UpdateYAML(path, UpdateOptions{IfMatch: "stale"}, &p, increment)
+	var exitErr *exitutil.Error
+	if !errors.As(err, &exitErr) || exitErr.Code != exitutil.PreconditionFailed {
+		t.Fatalf("expected precondition failure, got %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(original) {
+		t.Errorf("file was modified despite hash mismatch:\n%s", got)
+	}
+
+	if err := UpdateYAML(path, UpdateOptions{IfMatch: Hash(original)}, &p, increment); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "value: 2\n" {
+		t.Errorf("got %q, want %q", got, "value: 2\n")
+	}
+}
+
+// A dry run prints the change against the file as it would be re-marshaled,
+// so the hand-written flow-style list does not show up, and writes nothing.
+func Test_UpdateYAML_DryRun(t *testing.T) {
+	type payload struct {
+		Value int      `yaml:"value"`
+		Tags  []string `yaml:"tags"`
+	}
+
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+	original := []byte("value: 1\ntags: [a]\n")
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	var p payload
+	var diff bytes.Buffer
+	if err := UpdateYAML(path, UpdateOptions{DryRun: true, DiffOutput: &diff}, &p, func() error { p.Value++; return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "--- " + path + "\n+++ " + path + " (dry run)\n@@ -1,3 +1,3 @@\n-value: 1\n+value: 2\n tags:\n     - a\n"
+	if diff.String() != want {
+		t.Errorf("got diff:\n%s\nwant:\n%s", diff.String(), want)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(original) {
+		t.Errorf("dry run modified the file:\n%s", got)
+	}
+}
+
+// The diff of a dry run goes to stdout with plain output and to stderr with
+// structured output, so that stdout stays parseable.
+func Test_AddUpdateFlags_DiffOutput(t *testing.T) {
+	tests := []struct {
+		output       string
+		diffOnStdout bool
+	}{
+		{output: "plain", diffOnStdout: true},
+		{output: "json", diffOnStdout: false},
+		{output: "yaml", diffOnStdout: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ipam.yaml")
+			if err := os.WriteFile(path, []byte("value: 1\n"), 0o644); err != nil {
+				t.Fatalf("unexpected error writing file: %v", err)
+			}
+
+			var opts UpdateOptions
+			var output string
+			cmd := &cobra.Command{
+				Use: "test",
+				RunE: func(cmd *cobra.Command, args []string) error {
+					var p struct {
+						Value int `yaml:"value"`
+					}
+					if err := UpdateYAML(path, opts, &p, func() error { p.Value++; return nil }); err != nil {
+						return err
+					}
+					_, err := io.WriteString(cmd.OutOrStdout(), "result\n")
+					return err
+				},
+			}
+			cmd.Flags().StringVarP(&output, "output", "o", "plain", "")
+			AddUpdateFlags(cmd, &opts)
+
+			var stdout, stderr bytes.Buffer
+			cmd.SetOut(&stdout)
+			cmd.SetErr(&stderr)
+			cmd.SetArgs([]string{"--dry-run", "-o", tt.output})
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			diffOut, otherOut := &stdout, &stderr
+			if !tt.diffOnStdout {
+				diffOut, otherOut = &stderr, &stdout
+			}
+			if !strings.Contains(diffOut.String(), "+value: 2\n") {
+				t.Errorf("diff missing from the expected stream:\n%s", diffOut.String())
+			}
+			if strings.Contains(otherOut.String(), "+value: 2") {
+				t.Errorf("diff also written to the other stream:\n%s", otherOut.String())
+			}
+			if !strings.HasSuffix(stdout.String(), "result\n") {
+				t.Errorf("result missing from stdout:\n%s", stdout.String())
+			}
+		})
+	}
+}
+
+func Test_ReadFile_ReportsHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(path, []byte("value: 1\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	var gotPath, gotHash string
+	OnRead = func(path, hash string) { gotPath, gotHash = path, hash }
+	t.Cleanup(func() { OnRead = nil })
+
+	if _, err := ReadFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// sha256sum of "value: 1\n"
+	want := "76f5664c85b1d016e953c1aa05460763c3269b225ca34f889afb6f71e6721ec1"
+	if gotPath != path || gotHash != want {
+		t.Errorf("OnRead got (%q, %q), want (%q, %q)", gotPath, gotHash, path, want)
+	}
+}
@@ -0,0 +1,156 @@
+package fileutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLockTimeout is how long mutating commands wait for another
+// process to release an IPAM file before giving up.
+const DefaultLockTimeout = 10 * time.Second
+
+// lockRetryInterval is how often a waiting process checks the lock again.
+const lockRetryInterval = 50 * time.Millisecond
+
+// lockGracePeriod is how old a lock file that is not valid JSON must be
+// before it is taken as abandoned. A holder writes its details as soon as
+// it has created the file, so only one that crashed in between leaves it
+// empty or cut short for longer.
+const lockGracePeriod = 5 * time.Second
+
+// errCorruptLock is returned by readLock for a lock file that is not valid
+// JSON.
+var errCorruptLock = errors.New("not a valid lock file")
+
+// lockInfo is written into the lock file so that a waiting process can say
+// who holds the lock and detect holders that have died.
+type lockInfo struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+}
+
+// Lock takes an advisory lock on path by exclusively creating path+".lock",
+// waiting up to timeout for another holder to release it. A lock left
+// behind by a process on this host that no longer exists is treated as
+// stale and removed, as is one that has been invalid for longer than
+// lockGracePeriod. The returned function releases the lock.
+func Lock(path string, timeout time.Duration) (func(), error) {
+	lockPath := path + ".lock"
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(timeout)
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			err = json.NewEncoder(f).Encode(lockInfo{PID: os.Getpid(), Host: host, Created: time.Now().UTC()})
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(lockPath)
+				return nil, fmt.Errorf("error writing lock file: %v", err)
+			}
+			return func() { _ = os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("error creating lock file: %v", err)
+		}
+
+		holder, readErr := readLock(lockPath)
+		if errors.Is(readErr, fs.ErrNotExist) {
+			continue // released since we tried; try again
+		}
+		if readErr == nil && holder.Host == host && !processAlive(holder.PID) {
+			if err := breakStaleLock(lockPath, sameHolder(holder)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if errors.Is(readErr, errCorruptLock) {
+			if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockGracePeriod {
+				if err := breakStaleLock(lockPath, stillCorrupt(info.ModTime())); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			if readErr != nil {
+				return nil, fmt.Errorf("IPAM file %s is locked (%s exists but could not be read: %v)", path, lockPath, readErr)
+			}
+			return nil, fmt.Errorf("IPAM file %s is locked by pid %d on %s since %s; remove %s if that process is gone",
+				path, holder.PID, holder.Host, holder.Created.Format(time.RFC3339), lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// staleSeq makes the names breakStaleLock moves lock files to unique within
+// this process.
+var staleSeq atomic.Int64
+
+// breakStaleLock discards the lock file at lockPath if it is still the one
+// found stale. Another waiter may have broken the same lock and taken a
+// new one since it was read, so the file is first renamed to a name unique
+// to this attempt, which takes it out of play atomically, and checked
+// again with stillStale. Only a file that passes is removed; a live lock
+// caught by mistake is linked back into place, which fails rather than
+// overwrite a lock created in between.
+func breakStaleLock(lockPath string, stillStale func(aside string) bool) error {
+	aside := fmt.Sprintf("%s.stale.%d.%d", lockPath, os.Getpid(), staleSeq.Add(1))
+	if err := os.Rename(lockPath, aside); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // another waiter broke it first
+		}
+		return fmt.Errorf("error removing stale lock file: %v", err)
+	}
+	defer func() { _ = os.Remove(aside) }()
+
+	if stillStale(aside) {
+		return nil
+	}
+	if err := os.Link(aside, lockPath); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("error restoring lock file: %v", err)
+	}
+	return nil
+}
+
+// sameHolder reports whether a lock file still holds stale.
+func sameHolder(stale lockInfo) func(string) bool {
+	return func(aside string) bool {
+		taken, err := readLock(aside)
+		return err == nil && taken.PID == stale.PID && taken.Host == stale.Host && taken.Created.Equal(stale.Created)
+	}
+}
+
+// stillCorrupt reports whether a lock file is still invalid and has not
+// been written since modTime.
+func stillCorrupt(modTime time.Time) func(string) bool {
+	return func(aside string) bool {
+		info, err := os.Stat(aside)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return false
+		}
+		_, err = readLock(aside)
+		return errors.Is(err, errCorruptLock)
+	}
+}
+
+func readLock(lockPath string) (lockInfo, error) {
+	var info lockInfo
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("%w: %v", errCorruptLock, err)
+	}
+	return info, nil
+}
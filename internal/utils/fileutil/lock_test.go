@@ -0,0 +1,219 @@
+package fileutil
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Lock_ReleaseAndReacquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+
+	unlock, err := Lock(path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Fatalf("expected lock file to exist: %v", err)
+	}
+	unlock()
+
+	unlock, err = Lock(path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error re-acquiring lock: %v", err)
+	}
+	unlock()
+}
+
+func Test_Lock_TimeoutNamesHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+
+	unlock, err := Lock(path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(unlock)
+
+	start := time.Now()
+	_, err = Lock(path, 200*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if waited := time.Since(start); waited < 200*time.Millisecond {
+		t.Errorf("gave up after %v, want at least the timeout", waited)
+	}
+	host, _ := os.Hostname()
+	for _, want := range []string{"is locked by pid", host, path + ".lock"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err.Error(), want)
+		}
+	}
+}
+
+func Test_Lock_BreaksStaleLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stale lock detection needs a process liveness check")
+	}
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+
+	// Run a short-lived process so we have the pid of one that has exited.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("unexpected error running helper process: %v", err)
+	}
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(lockInfo{PID: cmd.ProcessState.Pid(), Host: host, Created: time.Now()})
+	if err := os.WriteFile(path+".lock", data, 0o644); err != nil {
+		t.Fatalf("unexpected error writing lock file: %v", err)
+	}
+
+	unlock, err := Lock(path, 0)
+	if err != nil {
+		t.Fatalf("expected stale lock to be broken, got: %v", err)
+	}
+	unlock()
+}
+
+// Waiters racing to break the same stale lock must still take the lock one
+// at a time: none of them may discard a lock another has just taken.
+func Test_Lock_ConcurrentWaitersOnStaleLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stale lock detection needs a process liveness check")
+	}
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("unexpected error running helper process: %v", err)
+	}
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(lockInfo{PID: cmd.ProcessState.Pid(), Host: host, Created: time.Now()})
+
+	for round := 0; round < 5; round++ {
+		if err := os.WriteFile(path+".lock", data, 0o644); err != nil {
+			t.Fatalf("unexpected error writing lock file: %v", err)
+		}
+
+		var holders, maxHolders atomic.Int32
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				unlock, err := Lock(path, 5*time.Second)
+				if err != nil {
+					errs <- err
+					return
+				}
+				n := holders.Add(1)
+				for {
+					m := maxHolders.Load()
+					if n <= m || maxHolders.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				holders.Add(-1)
+				unlock()
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := maxHolders.Load(); got != 1 {
+			t.Fatalf("round %d: %d waiters held the lock at once", round, got)
+		}
+	}
+}
+
+// The interleaving behind the race above, step by step: a waiter that read
+// the stale lock only gets to break it after another waiter has already
+// broken it and taken the lock. The live lock must survive.
+func Test_Lock_LateBreakKeepsLiveLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stale lock detection needs a process liveness check")
+	}
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("unexpected error running helper process: %v", err)
+	}
+	host, _ := os.Hostname()
+	stale := lockInfo{PID: cmd.ProcessState.Pid(), Host: host, Created: time.Now().UTC()}
+	data, _ := json.Marshal(stale)
+	if err := os.WriteFile(path+".lock", data, 0o644); err != nil {
+		t.Fatalf("unexpected error writing lock file: %v", err)
+	}
+
+	unlock, err := Lock(path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer unlock()
+	live, err := os.ReadFile(path + ".lock")
+	if err != nil {
+		t.Fatalf("unexpected error reading lock file: %v", err)
+	}
+
+	if err := breakStaleLock(path+".lock", sameHolder(stale)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(path + ".lock")
+	if err != nil {
+		t.Fatalf("live lock was removed: %v", err)
+	}
+	if string(got) != string(live) {
+		t.Errorf("lock file changed from %s to %s", live, got)
+	}
+	if _, err := Lock(path, 0); err == nil {
+		t.Errorf("took a lock that is still held")
+	}
+	if leftovers, _ := filepath.Glob(path + ".lock.stale.*"); len(leftovers) > 0 {
+		t.Errorf("left behind %v", leftovers)
+	}
+}
+
+// A holder that crashed between creating the lock file and writing its
+// details leaves it empty. Once that is older than the grace period it is
+// broken like any other stale lock; until then it is treated as held.
+func Test_Lock_BreaksAbandonedEmptyLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.yaml")
+	if err := os.WriteFile(path+".lock", nil, 0o644); err != nil {
+		t.Fatalf("unexpected error writing lock file: %v", err)
+	}
+
+	_, err := Lock(path, 0)
+	if err == nil {
+		t.Fatal("expected a fresh empty lock to be treated as held, got nil")
+	}
+	if !strings.Contains(err.Error(), "could not be read") {
+		t.Errorf("error %q does not say the lock could not be read", err.Error())
+	}
+
+	old := time.Now().Add(-2 * lockGracePeriod)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatalf("unexpected error ageing lock file: %v", err)
+	}
+	unlock, err := Lock(path, 0)
+	if err != nil {
+		t.Fatalf("expected abandoned lock to be broken, got: %v", err)
+	}
+	defer unlock()
+	if _, err := readLock(path + ".lock"); err != nil {
+		t.Errorf("expected a valid lock in its place, got: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(path + ".lock.stale.*"); len(leftovers) > 0 {
+		t.Errorf("left behind %v", leftovers)
+	}
+}
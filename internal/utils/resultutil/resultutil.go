@@ -0,0 +1,111 @@
+package resultutil
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/pflag"
+	"go.yaml.in/yaml/v4"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/treeutil"
+)
+
+// Actions reported in a Result.
+const (
+	Added     = "added"
+	Allocated = "allocated"
+	Existing  = "existing"
+	Deleted   = "deleted"
+	Assigned  = "assigned"
+	Released  = "released"
+	Updated   = "updated"
+	Moved     = "moved"
+)
+
+// Result is what a mutating command did to one subnet, or to one address
+// in it.
+type Result struct {
+	Action string `json:"action" yaml:"action"`
+	CIDR   string `json:"cidr" yaml:"cidr"`
+	// From is the CIDR a moved subnet had before.
+	From string `json:"from,omitempty" yaml:"from,omitempty"`
+	// IP is the address assigned or released, if any.
+	IP string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	// Parents is the ancestor path of the subnet, outermost first.
+	Parents []string `json:"parents" yaml:"parents"`
+	// Renested lists the existing subnets that changed parent: those
+	// adopted by an added subnet, or promoted out of a deleted one.
+	Renested []string `json:"renested" yaml:"renested"`
+	// Removed lists the subnets deleted along with a recursive delete.
+	Removed []string `json:"removed,omitempty" yaml:"removed,omitempty"`
+	// Discarded lists the host addresses deleted along with those subnets.
+	Discarded []string `json:"discarded,omitempty" yaml:"discarded,omitempty"`
+}
+
+// AddOutputFlag registers --output on a mutating command.
+func AddOutputFlag(fs *pflag.FlagSet, output *string) {
+	fs.StringVarP(output, "output", "o", "plain", "output format: plain, json or yaml")
+}
+
+// CheckFormat rejects an unknown --output value. Commands call it before
+// touching the file, so a typo does not leave a change made but unreported.
+func CheckFormat(output string) error {
+	if output != "plain" && output != "json" && output != "yaml" {
+		return fmt.Errorf("unknown output format %q. Must be one of plain, json or yaml", output)
+	}
+	return nil
+}
+
+// At returns a Result for cidr as it now sits in tree, with the subnets
+// under it reported as re-nested. It is meant for subnets that have just
+// been added, whose children can only be existing subnets it adopted.
+func At(tree map[string]models.Subnets, action, cidr string) Result {
+	path, node, _ := treeutil.Find(tree, cidr)
+	return Result{
+		Action:   action,
+		CIDR:     cidr,
+		Parents:  orEmpty(path),
+		Renested: orEmpty(treeutil.SortedCIDRs(node.Subnets)),
+	}
+}
+
+// Write prints results: one CIDR, IP for address results or "from -> to" for
+// moves, per line for plain, or the full results as a JSON or YAML list.
+func Write(w io.Writer, output string, results []Result) error {
+	if results == nil {
+		results = []Result{}
+	}
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "yaml":
+		data, err := yaml.Marshal(results)
+		if err != nil {
+			return fmt.Errorf("error marshaling results: %v", err)
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	for _, r := range results {
+		line := cmp.Or(r.IP, r.CIDR)
+		if r.From != "" {
+			line = r.From + " -> " + r.CIDR
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func orEmpty(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
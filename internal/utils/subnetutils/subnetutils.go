@@ -2,24 +2,31 @@ package subnetutils
 
 import (
 	"fmt"
+	"math/big"
 	"net"
+	"slices"
 )
 
 // Check if the subnet from user input is valid
 func CheckValidSubnet(subnetToAdd string) error {
-	ip, existingNet, err := net.ParseCIDR(subnetToAdd)
+	_, existingNet, err := net.ParseCIDR(subnetToAdd)
 	if err != nil {
 		return fmt.Errorf("error parsing existing CIDR: %v", err)
 	}
 	if subnetToAdd != existingNet.String() {
 		return fmt.Errorf("%v is not valid CIDR notation", subnetToAdd)
 	}
-	if ip.To4() == nil {
-		return fmt.Errorf("%v is not a valid IPv4 subnet", subnetToAdd)
-	}
 	return nil
 }
 
+// Family returns "IPv4" or "IPv6" depending on the address length of n.
+func Family(n *net.IPNet) string {
+	if _, bits := n.Mask.Size(); bits == 32 {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
 // Check if subnetToAdd is a subnet of an existing network
 func IsSubnetOf(subnet, subnetToAdd string) (bool, error) {
 	_, existingNet, err := net.ParseCIDR(subnet)
@@ -63,3 +70,201 @@ func IsSupernetOf(existingsubnet, subnetToAdd string) (bool, error) {
 
 	return false, nil
 }
+
+// IPToInt returns the numeric value of ip. bits selects the address length
+// (32 for IPv4, 128 for IPv6) so 4-byte and 16-byte forms of the same IPv4
+// address map to the same value.
+func IPToInt(ip net.IP, bits int) *big.Int {
+	if bits == 32 {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	return new(big.Int).SetBytes(ip)
+}
+
+// IntToIP is the inverse of IPToInt.
+func IntToIP(v *big.Int, bits int) net.IP {
+	ip := make(net.IP, bits/8)
+	v.FillBytes(ip)
+	return ip
+}
+
+// NetworkBounds returns the first and last address of n as integers.
+func NetworkBounds(n *net.IPNet) (first, last *big.Int) {
+	_, bits := n.Mask.Size()
+	first = IPToInt(n.IP.Mask(n.Mask), bits)
+	last = new(big.Int).Add(first, NetworkSize(n))
+	last.Sub(last, big.NewInt(1))
+	return first, last
+}
+
+// NetworkSize returns the number of addresses in n.
+func NetworkSize(n *net.IPNet) *big.Int {
+	ones, bits := n.Mask.Size()
+	return BlockSize(ones, bits)
+}
+
+// BlockSize returns the number of addresses in a /ones prefix of an address
+// family with the given number of bits.
+func BlockSize(ones, bits int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+}
+
+// PrefixForHosts returns the longest prefix length, i.e. the smallest
+// subnet, of a bits-long address family that leaves at least hosts usable
+// addresses once reserved of them are set aside.
+func PrefixForHosts(hosts, reserved, bits int) (int, error) {
+	if hosts < 1 {
+		return 0, fmt.Errorf("host count must be at least 1, got %d", hosts)
+	}
+	if reserved < 0 {
+		return 0, fmt.Errorf("reserved address count must not be negative, got %d", reserved)
+	}
+	need := new(big.Int).Add(big.NewInt(int64(hosts)), big.NewInt(int64(reserved)))
+	for ones := bits; ones >= 0; ones-- {
+		if BlockSize(ones, bits).Cmp(need) >= 0 {
+			return ones, nil
+		}
+	}
+	return 0, fmt.Errorf("%d hosts plus %d reserved addresses do not fit in a %d-bit address space", hosts, reserved, bits)
+}
+
+// NetFromInt builds the /ones network starting at start.
+func NetFromInt(start *big.Int, ones, bits int) *net.IPNet {
+	return &net.IPNet{IP: IntToIP(start, bits), Mask: net.CIDRMask(ones, bits)}
+}
+
+// CompareNets orders networks numerically: IPv4 before IPv6, then by
+// network address, then shorter prefixes (supernets) first.
+func CompareNets(a, b *net.IPNet) int {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+	if aBits != bBits {
+		return aBits - bBits
+	}
+	if c := IPToInt(a.IP, aBits).Cmp(IPToInt(b.IP, bBits)); c != 0 {
+		return c
+	}
+	return aOnes - bOnes
+}
+
+// RangeToCIDRs returns the minimal list of networks, in address order, that
+// exactly covers the addresses first through last.
+func RangeToCIDRs(first, last *big.Int, bits int) []*net.IPNet {
+	var out []*net.IPNet
+	cursor := new(big.Int).Set(first)
+	for cursor.Cmp(last) <= 0 {
+		// Start from the largest block cursor is aligned to, then shrink
+		// it until it no longer runs past last.
+		ones := bits - int(cursor.TrailingZeroBits())
+		if cursor.Sign() == 0 {
+			ones = 0
+		}
+		for {
+			end := new(big.Int).Add(cursor, BlockSize(ones, bits))
+			if end.Sub(end, big.NewInt(1)).Cmp(last) <= 0 {
+				break
+			}
+			ones++
+		}
+		out = append(out, NetFromInt(cursor, ones, bits))
+		cursor.Add(cursor, BlockSize(ones, bits))
+	}
+	return out
+}
+
+// Subtract returns the minimal list of networks covering the part of parent
+// that none of used overlaps. used may be in any order and may nest.
+func Subtract(parent *net.IPNet, used []*net.IPNet) []*net.IPNet {
+	_, bits := parent.Mask.Size()
+	sorted := slices.Clone(used)
+	slices.SortFunc(sorted, CompareNets)
+
+	var out []*net.IPNet
+	cursor, parentLast := NetworkBounds(parent)
+	for _, u := range sorted {
+		first, last := NetworkBounds(u)
+		if _, uBits := u.Mask.Size(); uBits != bits || last.Cmp(cursor) < 0 || first.Cmp(parentLast) > 0 {
+			continue
+		}
+		if first.Cmp(cursor) > 0 {
+			out = append(out, RangeToCIDRs(cursor, new(big.Int).Sub(first, big.NewInt(1)), bits)...)
+		}
+		cursor = last.Add(last, big.NewInt(1))
+	}
+	if cursor.Cmp(parentLast) <= 0 {
+		out = append(out, RangeToCIDRs(cursor, parentLast, bits)...)
+	}
+	return out
+}
+
+// Aggregate returns the minimal list of networks, IPv4 first and then in
+// address order, that covers exactly the addresses of nets. Contained blocks
+// are dropped and adjacent ones merged into their common supernet where the
+// alignment allows.
+func Aggregate(nets []*net.IPNet) []*net.IPNet {
+	sorted := slices.Clone(nets)
+	slices.SortFunc(sorted, CompareNets)
+
+	var out []*net.IPNet
+	var first, last *big.Int
+	bits := 0
+	flush := func() {
+		if first != nil {
+			out = append(out, RangeToCIDRs(first, last, bits)...)
+		}
+	}
+	for _, n := range sorted {
+		nFirst, nLast := NetworkBounds(n)
+		_, nBits := n.Mask.Size()
+		if first != nil && nBits == bits && nFirst.Cmp(new(big.Int).Add(last, big.NewInt(1))) <= 0 {
+			if nLast.Cmp(last) > 0 {
+				last = nLast
+			}
+			continue
+		}
+		flush()
+		first, last, bits = nFirst, nLast, nBits
+	}
+	flush()
+	return out
+}
+
+// Percent returns part as a percentage of whole.
+func Percent(part, whole *big.Int) float64 {
+	if whole.Sign() == 0 {
+		return 0
+	}
+	p, _ := new(big.Rat).SetFrac(new(big.Int).Mul(part, big.NewInt(100)), whole).Float64()
+	return p
+}
+
+// Covered returns how many addresses of parent at least one of used overlaps.
+func Covered(parent *net.IPNet, used []*net.IPNet) *big.Int {
+	covered := NetworkSize(parent)
+	for _, n := range Subtract(parent, used) {
+		covered.Sub(covered, NetworkSize(n))
+	}
+	return covered
+}
+
+// CandidateBlocked reports whether the candidate would displace existing
+// address space. A descendant blocks the candidate iff its range is fully
+// within (or equal to) the candidate's range — i.e., the descendant's
+// prefix is at least as long as the candidate's and its network IP falls
+// inside the candidate. Descendants that are strict supernets of the
+// candidate are not blockers; they are containers the candidate can nest
+// inside.
+func CandidateBlocked(candidate *net.IPNet, candOnes int, descendants []*net.IPNet) bool {
+	for _, d := range descendants {
+		dOnes, _ := d.Mask.Size()
+		if dOnes < candOnes {
+			continue // d is bigger than candidate; it is a potential container, not a blocker
+		}
+		if candidate.Contains(d.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package treeutil
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+)
+
+// Entry is one subnet of an indexed tree.
+type Entry struct {
+	CIDR string
+	// Path holds the CIDRs of the subnet's ancestors, outermost first.
+	Path []string
+	Node models.Subnets
+}
+
+// Index finds the subnets of a tree containing an address by longest-prefix
+// match: one map lookup per prefix length in use, longest first, so lookups
+// stay cheap however many subnets the tree holds.
+type Index struct {
+	byCIDR map[string]Entry
+	v4, v6 prefixTable
+}
+
+// prefixTable maps a masked network address, as a string of its bytes, to
+// the CIDR of the subnet, per prefix length.
+type prefixTable struct {
+	lengths []int // longest first
+	nets    map[int]map[string]string
+}
+
+// NewIndex indexes every subnet of tree.
+func NewIndex(tree map[string]models.Subnets) (*Index, error) {
+	ix := &Index{byCIDR: map[string]Entry{}}
+	err := Walk(tree, func(path []string, cidr string, node models.Subnets) error {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		ix.byCIDR[cidr] = Entry{CIDR: cidr, Path: slices.Clone(path), Node: node}
+		ones, bits := n.Mask.Size()
+		t := &ix.v6
+		if bits == 32 {
+			t = &ix.v4
+		}
+		if t.nets == nil {
+			t.nets = map[int]map[string]string{}
+		}
+		if t.nets[ones] == nil {
+			t.nets[ones] = map[string]string{}
+			t.lengths = append(t.lengths, ones)
+		}
+		t.nets[ones][string(n.IP)] = cidr
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range []*prefixTable{&ix.v4, &ix.v6} {
+		slices.SortFunc(t.lengths, func(a, b int) int { return b - a })
+	}
+	return ix, nil
+}
+
+// Lookup returns the subnets containing ip, outermost first, or nil if none
+// does.
+func (ix *Index) Lookup(ip net.IP) []Entry {
+	t, bits := &ix.v6, 128
+	if v4 := ip.To4(); v4 != nil {
+		t, bits, ip = &ix.v4, 32, v4
+	}
+	for _, ones := range t.lengths {
+		cidr, ok := t.nets[ones][string(ip.Mask(net.CIDRMask(ones, bits)))]
+		if !ok {
+			continue
+		}
+		e := ix.byCIDR[cidr]
+		chain := make([]Entry, 0, len(e.Path)+1)
+		for _, ancestor := range e.Path {
+			chain = append(chain, ix.byCIDR[ancestor])
+		}
+		return append(chain, e)
+	}
+	return nil
+}
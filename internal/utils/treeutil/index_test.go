@@ -0,0 +1,110 @@
+package treeutil
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+)
+
+func Test_IndexLookup(t *testing.T) {
+	tree := map[string]models.Subnets{
+		"10.0.0.0/8": {
+			Description: "corp",
+			Subnets: map[string]models.Subnets{
+				"10.1.0.0/16": {
+					Subnets: map[string]models.Subnets{
+						"10.1.2.0/24": {
+							Subnets: map[string]models.Subnets{
+								"10.1.2.128/25": {Subnets: map[string]models.Subnets{}},
+							},
+						},
+					},
+				},
+				"10.2.0.0/16": {Subnets: map[string]models.Subnets{}},
+			},
+		},
+		"192.168.0.0/24": {Subnets: map[string]models.Subnets{}},
+		"2001:db8::/32": {
+			Subnets: map[string]models.Subnets{
+				"2001:db8:1::/48": {Subnets: map[string]models.Subnets{}},
+			},
+		},
+	}
+	ix, err := NewIndex(tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want []string
+	}{
+		{name: "top level", ip: "192.168.0.7", want: []string{"192.168.0.0/24"}},
+		{name: "nested chain", ip: "10.1.2.200", want: []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.128/25"}},
+		{name: "stops at the deepest match", ip: "10.1.2.5", want: []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24"}},
+		{name: "sibling branch", ip: "10.2.255.255", want: []string{"10.0.0.0/8", "10.2.0.0/16"}},
+		{name: "only the top level matches", ip: "10.3.0.1", want: []string{"10.0.0.0/8"}},
+		{name: "IPv4 outside every subnet", ip: "172.16.0.1", want: nil},
+		{name: "IPv6", ip: "2001:db8:1::1", want: []string{"2001:db8::/32", "2001:db8:1::/48"}},
+		{name: "IPv6 outside every subnet", ip: "2001:db9::1", want: nil},
+		{name: "v4-mapped matches IPv4", ip: "::ffff:10.1.2.200", want: []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.128/25"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range ix.Lookup(net.ParseIP(tt.ip)) {
+				got = append(got, e.CIDR)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_IndexEntries(t *testing.T) {
+	tree := map[string]models.Subnets{
+		"10.0.0.0/8": {
+			Description: "corp",
+			Subnets: map[string]models.Subnets{
+				"10.1.0.0/16": {Description: "us-east", Tags: []string{"region"}, Subnets: map[string]models.Subnets{}},
+			},
+		},
+	}
+	ix, err := NewIndex(tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	chain := ix.Lookup(net.ParseIP("10.1.0.1"))
+	if len(chain) != 2 {
+		t.Fatalf("got %d entries, want 2", len(chain))
+	}
+	if e := chain[0]; e.CIDR != "10.0.0.0/8" || len(e.Path) != 0 || e.Node.Description != "corp" {
+		t.Errorf("got outer entry %+v", e)
+	}
+	if e := chain[1]; e.CIDR != "10.1.0.0/16" || !slices.Equal(e.Path, []string{"10.0.0.0/8"}) || e.Node.Description != "us-east" || !slices.Equal(e.Node.Tags, []string{"region"}) {
+		t.Errorf("got inner entry %+v", e)
+	}
+}
+
+func Test_NewIndexCorrupt(t *testing.T) {
+	tree := map[string]models.Subnets{
+		"10.0.0.0/8": {
+			Subnets: map[string]models.Subnets{
+				"10.1.0.0/33": {Subnets: map[string]models.Subnets{}},
+			},
+		},
+	}
+	_, err := NewIndex(tree)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want := `corrupt IPAM: "10.1.0.0/33": invalid CIDR address: 10.1.0.0/33`; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
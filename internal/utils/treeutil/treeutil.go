@@ -0,0 +1,281 @@
+package treeutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/kyle-burnett/simple-ipam/internal/models"
+	"github.com/kyle-burnett/simple-ipam/internal/utils/subnetutils"
+)
+
+// SkipChildren can be returned from a WalkFunc to stop Walk descending into
+// the current node's subnets. It is not returned as an error by Walk.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is called by Walk for every node in the tree. path holds the
+// CIDRs of the node's ancestors, outermost first.
+type WalkFunc func(path []string, cidr string, node models.Subnets) error
+
+// SortedCIDRs returns the keys of m in numeric address order rather than the
+// lexicographic order YAML marshaling uses, so 10.2.0.0/16 comes before
+// 10.10.0.0/20. Keys that do not parse as CIDRs sort last, by string.
+func SortedCIDRs(m map[string]models.Subnets) []string {
+	keys := make([]string, 0, len(m))
+	nets := make(map[string]*net.IPNet, len(m))
+	for k := range m {
+		keys = append(keys, k)
+		if _, n, err := net.ParseCIDR(k); err == nil {
+			nets[k] = n
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		na, nb := nets[a], nets[b]
+		switch {
+		case na != nil && nb != nil:
+			if c := subnetutils.CompareNets(na, nb); c != 0 {
+				return c
+			}
+		case na != nil:
+			return -1
+		case nb != nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return keys
+}
+
+// Walk visits every node under m depth-first in numeric address order.
+func Walk(m map[string]models.Subnets, fn WalkFunc) error {
+	return walk(nil, m, fn)
+}
+
+func walk(path []string, m map[string]models.Subnets, fn WalkFunc) error {
+	for _, cidr := range SortedCIDRs(m) {
+		node := m[cidr]
+		err := fn(path, cidr, node)
+		if errors.Is(err, SkipChildren) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := walk(append(slices.Clip(path), cidr), node.Subnets, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContainingMap returns the subnets map that holds cidr as a key, so that a
+// modified copy of the node can be written back.
+func ContainingMap(m map[string]models.Subnets, cidr string) (map[string]models.Subnets, bool) {
+	path, _, ok := Find(m, cidr)
+	if !ok {
+		return nil, false
+	}
+	for _, p := range path {
+		m = m[p].Subnets
+	}
+	return m, true
+}
+
+// Find locates cidr anywhere under m, following only the branches whose
+// keys contain it. It returns the ancestor path of the node and the node.
+func Find(m map[string]models.Subnets, cidr string) ([]string, models.Subnets, bool) {
+	var path []string
+	for {
+		if node, ok := m[cidr]; ok {
+			return path, node, true
+		}
+		next := ""
+		for key := range m {
+			if isSubnet, err := subnetutils.IsSubnetOf(key, cidr); err == nil && isSubnet {
+				next = key
+				break
+			}
+		}
+		if next == "" {
+			return nil, models.Subnets{}, false
+		}
+		path = append(path, next)
+		m = m[next].Subnets
+	}
+}
+
+// Nets walks the subtree rooted at tree and returns every subnet it
+// contains, parsed to *net.IPNet. Errors on any malformed CIDR key.
+func Nets(tree map[string]models.Subnets) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	var walk func(m map[string]models.Subnets) error
+	walk = func(m map[string]models.Subnets) error {
+		for cidr, node := range m {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+			}
+			out = append(out, n)
+			if err := walk(node.Subnets); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(tree); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InsertAtDeepest inserts entry under candidate's deepest existing ancestor
+// in tree. tree is the direct-children map of the parent; candidate is
+// assumed to be free (not blocked by any descendant) and strictly inside
+// the parent's range. The CIDR invariant (at most one sibling at a given
+// level can contain a given address) guarantees only one branch matches.
+func InsertAtDeepest(tree map[string]models.Subnets, candidate *net.IPNet, entry models.Subnets) error {
+	candOnes, _ := candidate.Mask.Size()
+
+	for cidr, values := range tree {
+		_, existing, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		existingOnes, _ := existing.Mask.Size()
+
+		if existingOnes < candOnes && existing.Contains(candidate.IP) {
+			if values.Subnets == nil {
+				values.Subnets = make(map[string]models.Subnets)
+			}
+			if err := InsertAtDeepest(values.Subnets, candidate, entry); err != nil {
+				return err
+			}
+			tree[cidr] = values // write-back for nil-init propagation
+			return nil
+		}
+	}
+
+	tree[candidate.String()] = entry
+	return nil
+}
+
+// Flatten returns every node under m keyed by its CIDR, with Subnets left
+// empty, so the set can be edited without regard to nesting and turned back
+// into a tree with Build.
+func Flatten(m map[string]models.Subnets) map[string]models.Subnets {
+	flat := map[string]models.Subnets{}
+	_ = Walk(m, func(path []string, cidr string, node models.Subnets) error {
+		node.Subnets = map[string]models.Subnets{}
+		flat[cidr] = node
+		return nil
+	})
+	return flat
+}
+
+// Build nests a flat set of subnets, each under its smallest enclosing
+// subnet in the set. The Subnets of the values are ignored.
+func Build(flat map[string]models.Subnets) (map[string]models.Subnets, error) {
+	nets := make([]*net.IPNet, 0, len(flat))
+	for cidr := range flat {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt IPAM: %q: %w", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	// Supernets sort ahead of the subnets they contain, so each entry's
+	// ancestors are already in place by the time it is inserted.
+	slices.SortFunc(nets, subnetutils.CompareNets)
+
+	tree := map[string]models.Subnets{}
+	for _, n := range nets {
+		node := flat[n.String()]
+		node.Subnets = map[string]models.Subnets{}
+		if err := InsertAtDeepest(tree, n, node); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// ShiftAddresses returns a copy of addrs with every IP moved by offset, for
+// a subnet that is being renumbered.
+func ShiftAddresses(addrs map[string]models.Address, offset *big.Int, bits int) (map[string]models.Address, error) {
+	if addrs == nil {
+		return nil, nil
+	}
+	out := make(map[string]models.Address, len(addrs))
+	for ip, a := range addrs {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("corrupt IPAM: %q is not a valid IP address", ip)
+		}
+		v := new(big.Int).Add(subnetutils.IPToInt(parsed, bits), offset)
+		out[subnetutils.IntToIP(v, bits).String()] = a
+	}
+	return out, nil
+}
+
+// SortedAddresses returns the keys of addrs in numeric address order. Keys
+// that do not parse as IP addresses sort last, by string.
+func SortedAddresses(addrs map[string]models.Address) []string {
+	keys := make([]string, 0, len(addrs))
+	ips := make(map[string]net.IP, len(addrs))
+	for k := range addrs {
+		keys = append(keys, k)
+		if ip := net.ParseIP(k); ip != nil {
+			ips[k] = ip.To16()
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		ia, ib := ips[a], ips[b]
+		switch {
+		case ia != nil && ib != nil:
+			if c := bytes.Compare(ia, ib); c != 0 {
+				return c
+			}
+		case ia != nil:
+			return -1
+		case ib != nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return keys
+}
+
+// InUseError is returned when a subnet would be nested under Subnet, which
+// has Addresses assigned in it. Addresses are only kept in leaf subnets, so
+// they have to be released before anything can go under it.
+type InUseError struct {
+	Subnet    string
+	Addresses []string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("%s has address(es) assigned in it (%s); release them before nesting subnets under it", e.Subnet, strings.Join(e.Addresses, ", "))
+}
+
+// CheckNestable returns an *InUseError if node, the subnet cidr, has
+// addresses assigned in it, so that no subnet may be nested under it.
+func CheckNestable(cidr string, node models.Subnets) error {
+	if len(node.Addresses) == 0 {
+		return nil
+	}
+	return &InUseError{Subnet: cidr, Addresses: SortedAddresses(node.Addresses)}
+}
+
+// CheckLeafAddresses returns an *InUseError for the first subnet of tree
+// that has both subnets under it and addresses assigned in it.
+func CheckLeafAddresses(tree map[string]models.Subnets) error {
+	return Walk(tree, func(path []string, cidr string, node models.Subnets) error {
+		if len(node.Subnets) == 0 {
+			return nil
+		}
+		return CheckNestable(cidr, node)
+	})
+}